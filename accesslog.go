@@ -0,0 +1,160 @@
+package rapid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// RequestID is injected into handlers when access logging is enabled, and
+// identifies the request in the access log, the X-Request-ID response header
+// and encoded errors.
+type RequestID string
+
+// RequestIDFromContext returns the ID of the request that ctx was injected
+// into, or "".
+func RequestIDFromContext(ctx context.Context) RequestID {
+	id, _ := ctx.Value(requestIDContextKey).(RequestID)
+	return id
+}
+
+// Request IDs accepted from the X-Request-ID header. Others are replaced.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// newRequestID returns the ID from the X-Request-ID header of a request, if
+// it is valid, or a new random ID.
+func newRequestID(r *http.Request) RequestID {
+	if id := r.Header.Get("X-Request-ID"); validRequestID.MatchString(id) {
+		return RequestID(id)
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return RequestID(hex.EncodeToString(b))
+}
+
+// AccessLogFormat is the format of access log records.
+type AccessLogFormat int
+
+const (
+	// AccessLogJSON writes each record as a JSON object on its own line.
+	AccessLogJSON AccessLogFormat = iota
+	// AccessLogCommon writes records in the Common Log Format. Request IDs,
+	// route names and durations are not included.
+	AccessLogCommon
+)
+
+// AccessLog writes a record of each request to w, in the given format.
+//
+// Each request is assigned an ID, taken from its X-Request-ID header if
+// present or generated otherwise. The ID is injected into handlers as a
+// RequestID, sent in the X-Request-ID response header, and included in
+// encoded errors.
+func (s *Server) AccessLog(w io.Writer, format AccessLogFormat) *Server {
+	s.accessLog = &accessLogger{w: w, format: format}
+	return s
+}
+
+type accessLogger struct {
+	lock   sync.Mutex
+	w      io.Writer
+	format AccessLogFormat
+}
+
+type accessLogRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  RequestID `json:"request_id"`
+	Route      string    `json:"route,omitempty"` // Empty if no route matched.
+	Method     string    `json:"method"`
+	Path       string    `json:"path"` // Without the query string, which may carry credentials.
+	Proto      string    `json:"-"`
+	Status     int       `json:"status"`
+	Duration   float64   `json:"duration"` // In seconds.
+	Bytes      int64     `json:"bytes"`
+	RemoteAddr string    `json:"remote_addr"`
+	Principal  string    `json:"principal,omitempty"` // Only principals that implement fmt.Stringer are logged.
+}
+
+// accessLogEntry is the access log record of a request being served.
+type accessLogEntry struct {
+	logger *accessLogger
+	w      *recordingResponseWriter
+	record accessLogRecord
+	log    Logger
+}
+
+// begin logging a request, whose response is written to w. It returns the
+// request with its ID. end() must be called once the request has been
+// served.
+func (a *accessLogger) begin(w *recordingResponseWriter, r *http.Request, log Logger) (*accessLogEntry, *http.Request) {
+	id := newRequestID(r)
+	w.Header().Set("X-Request-ID", string(id))
+	entry := &accessLogEntry{
+		logger: a,
+		w:      w,
+		log:    log,
+		record: accessLogRecord{
+			Time:       time.Now(),
+			RequestID:  id,
+			Method:     r.Method,
+			Path:       r.URL.EscapedPath(),
+			Proto:      r.Proto,
+			RemoteAddr: r.RemoteAddr,
+		},
+	}
+	return entry, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id))
+}
+
+// principal records the authenticated principal of the request, if it
+// implements fmt.Stringer. Other principals may contain credentials.
+func (e *accessLogEntry) principal(principal Principal) {
+	if stringer, ok := principal.(fmt.Stringer); ok {
+		e.record.Principal = stringer.String()
+	}
+}
+
+// end writes the record of the request.
+func (e *accessLogEntry) end() {
+	record := &e.record
+	record.Duration = time.Since(record.Time).Seconds()
+	record.Status = e.w.Status()
+	record.Bytes = e.w.size
+	a := e.logger
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var err error
+	switch a.format {
+	case AccessLogCommon:
+		_, err = io.WriteString(a.w, record.common())
+	default:
+		err = json.NewEncoder(a.w).Encode(record)
+	}
+	if err != nil {
+		e.log.Errorf("access log: %s", err)
+	}
+}
+
+// common formats the record in the Common Log Format.
+func (r *accessLogRecord) common() string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := r.Principal
+	if user == "" {
+		user = "-"
+	}
+	bytes := "-"
+	if r.Bytes > 0 {
+		bytes = fmt.Sprint(r.Bytes)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s\n", host, user, r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.Path, r.Proto, r.Status, bytes)
+}
@@ -0,0 +1,131 @@
+package rapid
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testAccessLogServer struct{}
+
+type testAccessLogUser string
+
+func (u testAccessLogUser) String() string { return string(u) }
+
+func (t *testAccessLogServer) Echo(id RequestID) (string, error) {
+	return string(id), nil
+}
+
+func (t *testAccessLogServer) Fail() error {
+	return Error(http.StatusTeapot, "no coffee")
+}
+
+// testAccessLogAuth authenticates any user, with principals that are
+// fmt.Stringers except for "anonymous".
+var testAccessLogAuth = BasicAuth("test", func(username, password string) (Principal, error) {
+	if username == "anonymous" {
+		return username, nil
+	}
+	return testAccessLogUser(username), nil
+})
+
+func decodeTestAccessLog(t *testing.T, w *bytes.Buffer) map[string]interface{} {
+	record := map[string]interface{}{}
+	assert.NoError(t, json.NewDecoder(w).Decode(&record))
+	return record
+}
+
+func TestAccessLogJSON(t *testing.T) {
+	log := &bytes.Buffer{}
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").Get().SecuredBy("basic").Response(200, "")
+	svr := newTestServer(t, svc.Build(), &testAccessLogServer{}).SecurityScheme("basic", testAccessLogAuth).AccessLog(log, AccessLogJSON)
+
+	r := httptest.NewRequest("GET", "/echo?api_key=secret", nil)
+	r.RemoteAddr = "1.2.3.4:1000"
+	r.SetBasicAuth("alice", "")
+	r.Header.Set("X-Request-ID", "abc-123")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+	assert.Equal(t, "\"abc-123\"\n", w.Body.String())
+
+	record := decodeTestAccessLog(t, log)
+	assert.Equal(t, "abc-123", record["request_id"])
+	assert.Equal(t, "Echo", record["route"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/echo", record["path"])
+	assert.Equal(t, 200.0, record["status"])
+	assert.Equal(t, float64(len("\"abc-123\"\n")), record["bytes"])
+	assert.Equal(t, "1.2.3.4:1000", record["remote_addr"])
+	assert.Equal(t, "alice", record["principal"])
+	assert.Contains(t, record, "duration")
+	assert.Contains(t, record, "time")
+
+	// Requests without a valid ID are assigned one.
+	r = httptest.NewRequest("GET", "/missing", nil)
+	r.Header.Set("X-Request-ID", "not valid")
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	id := w.Header().Get("X-Request-ID")
+	assert.Len(t, id, 32)
+	record = decodeTestAccessLog(t, log)
+	assert.Equal(t, id, record["request_id"])
+	assert.Equal(t, 404.0, record["status"])
+	assert.NotContains(t, record, "route")
+	assert.NotContains(t, record, "principal")
+
+	// Principals that are not a fmt.Stringer are not logged.
+	r = httptest.NewRequest("GET", "/echo", nil)
+	r.SetBasicAuth("anonymous", "")
+	svr.ServeHTTP(httptest.NewRecorder(), r)
+	record = decodeTestAccessLog(t, log)
+	assert.Equal(t, 200.0, record["status"])
+	assert.NotContains(t, record, "principal")
+}
+
+func TestAccessLogCommon(t *testing.T) {
+	log := &bytes.Buffer{}
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").Get().SecuredBy("basic").Response(200, "")
+	svr := newTestServer(t, svc.Build(), &testAccessLogServer{}).SecurityScheme("basic", testAccessLogAuth).AccessLog(log, AccessLogCommon)
+	r := httptest.NewRequest("GET", "/echo", nil)
+	r.RemoteAddr = "1.2.3.4:1000"
+	r.SetBasicAuth("alice", "")
+	r.Header.Set("X-Request-ID", "abc")
+	svr.ServeHTTP(httptest.NewRecorder(), r)
+	r = httptest.NewRequest("GET", "/echo", nil)
+	r.RemoteAddr = "1.2.3.4:1000"
+	svr.ServeHTTP(httptest.NewRecorder(), r)
+	lines := bytes.Split(bytes.TrimSpace(log.Bytes()), []byte("\n"))
+	assert.Equal(t, 2, len(lines))
+	assert.Regexp(t, `^1\.2\.3\.4 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [-+]\d{4}\] "GET /echo HTTP/1\.1" 200 6$`, string(lines[0]))
+	assert.Regexp(t, `^1\.2\.3\.4 - - \[.*\] "GET /echo HTTP/1\.1" 401 \d+$`, string(lines[1]))
+}
+
+func TestAccessLogErrorRequestID(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Fail", "/fail").Get().Response(204, nil)
+	svr := newTestServer(t, svc.Build(), &testAccessLogServer{}).AccessLog(&bytes.Buffer{}, AccessLogJSON)
+	r := httptest.NewRequest("GET", "/fail", nil)
+	r.Header.Set("X-Request-ID", "abc")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Equal(t, "{\"e\":\"no coffee\",\"request_id\":\"abc\"}\n", w.Body.String())
+
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+	err = client.Do(Request(nil, "GET", "/fail").Build(), &indexResponse{})
+	status, ok := err.(*HTTPStatus)
+	assert.True(t, ok)
+	assert.Equal(t, "no coffee", status.Message)
+	assert.Len(t, status.RequestID, 32)
+}
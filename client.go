@@ -1,7 +1,11 @@
 package rapid
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,11 +18,21 @@ type BeforeClientRequest func(*http.Request) error
 type Client interface {
 	BeforeRequest(hook BeforeClientRequest) error
 	Do(req *RequestTemplate, resp interface{}) error
+	// DoContext is like Do, but the request is cancelled when ctx is done.
+	DoContext(ctx context.Context, req *RequestTemplate, resp interface{}) error
+	DoStreaming(req *RequestTemplate) (ClientStream, error)
+	DoStreamingContext(ctx context.Context, req *RequestTemplate) (ClientStream, error)
+	DoWebSocket(req *RequestTemplate) (ClientConn, error)
+	DoWebSocketContext(ctx context.Context, req *RequestTemplate) (ClientConn, error)
 	Close() error
 	HTTPClient() *http.Client
 }
 
+// A ClientStream is a stream of values returned by a streaming route.
 type ClientStream interface {
+	// Next decodes the next value in the stream into v. io.EOF is returned
+	// at the end of the stream, and in-band error frames are returned as
+	// *HTTPStatus errors.
 	Next(v interface{}) error
 	Close() error
 }
@@ -67,6 +81,8 @@ type RequestBuilder struct {
 	path     string
 	query    interface{}
 	body     interface{}
+	compress bool
+	headers  http.Header
 }
 
 // Request makes a new RequestBuilder. A RequestBuilder is a type with useful
@@ -92,6 +108,18 @@ func (r *RequestBuilder) Query(query interface{}) *RequestBuilder {
 	return r
 }
 
+// Header defines headers for a request. It accepts either http.Header or a
+// struct with "header" tags, as used by route.Header().
+func (r *RequestBuilder) Header(headers interface{}) *RequestBuilder {
+	if r.headers == nil {
+		r.headers = http.Header{}
+	}
+	for key, values := range EncodeStructToHeader(headers) {
+		r.headers[http.CanonicalHeaderKey(key)] = values
+	}
+	return r
+}
+
 // Body sets the JSON-encoded body of the request.
 func (r *RequestBuilder) Body(v interface{}) *RequestBuilder {
 	r.body = v
@@ -115,21 +143,69 @@ func (r *RequestBuilder) Data(data []byte) *RequestBuilder {
 	return r
 }
 
+// Compress the body of the request with gzip. The server must support
+// compressed requests.
+func (r *RequestBuilder) Compress() *RequestBuilder {
+	r.compress = true
+	return r
+}
+
+// IfMatch makes the request conditional on the resource's current entity tag
+// matching etag. The server responds with 412 Precondition Failed if it has
+// been modified.
+func (r *RequestBuilder) IfMatch(etag string) *RequestBuilder {
+	return r.header("If-Match", quoteETag(etag))
+}
+
+// IfNoneMatch makes the request conditional on the resource's current entity
+// tag not matching etag. The server responds with 304 Not Modified if it has
+// not been modified.
+func (r *RequestBuilder) IfNoneMatch(etag string) *RequestBuilder {
+	return r.header("If-None-Match", quoteETag(etag))
+}
+
+func (r *RequestBuilder) header(key, value string) *RequestBuilder {
+	if r.headers == nil {
+		r.headers = http.Header{}
+	}
+	r.headers.Set(key, value)
+	return r
+}
+
 func (r *RequestBuilder) Build() *RequestTemplate {
 	path := strings.TrimLeft(r.path, "/")
 	q := EncodeStructToURLValues(r.query)
 	if len(q) > 0 {
 		path += "?" + q.Encode()
 	}
-	_, bi := valueAndInterface(r.body)
-	headers, bodyr, err := r.codec.Request(bi).EncodeRequest()
-	if err != nil {
-		panic(err)
+	// Requests without a body are sent without one, so that the server can
+	// detect the client disconnecting.
+	headers := http.Header{}
+	var body []byte
+	if r.body != nil {
+		_, bi := valueAndInterface(r.body)
+		var bodyr io.ReadCloser
+		var err error
+		headers, bodyr, err = r.codec.Request(bi).EncodeRequest()
+		if err != nil {
+			panic(err)
+		}
+		defer bodyr.Close()
+		body, err = ioutil.ReadAll(bodyr)
+		if err != nil {
+			panic(err)
+		}
 	}
-	defer bodyr.Close()
-	body, err := ioutil.ReadAll(bodyr)
-	if err != nil {
-		panic(err)
+	if r.compress && len(body) > 0 {
+		w := &bytes.Buffer{}
+		gz := gzip.NewWriter(w)
+		gz.Write(body)
+		gz.Close()
+		body = w.Bytes()
+		headers.Set("Content-Encoding", "gzip")
+	}
+	for key, values := range r.headers {
+		headers[key] = values
 	}
 	return &RequestTemplate{
 		codec:   r.codec,
@@ -167,21 +243,163 @@ func (b *BasicClient) BeforeRequest(hook BeforeClientRequest) error {
 }
 
 func (b *BasicClient) Do(req *RequestTemplate, resp interface{}) error {
-	hr := req.Build(b.url)
+	return b.DoContext(context.Background(), req, resp)
+}
+
+func (b *BasicClient) DoContext(ctx context.Context, req *RequestTemplate, resp interface{}) error {
+	_, err := b.doResponse(ctx, req, resp)
+	return err
+}
+
+// DoETag is like DoContext, but also returns the entity tag of the response,
+// for use with RequestBuilder.IfMatch() and RequestBuilder.IfNoneMatch().
+//
+// If the server responds with 304 Not Modified, resp is left unchanged and an
+// *HTTPStatus error with that status is returned.
+func (b *BasicClient) DoETag(ctx context.Context, req *RequestTemplate, resp interface{}) (string, error) {
+	response, err := b.doResponse(ctx, req, resp)
+	if response == nil {
+		return "", err
+	}
+	return response.Header.Get("ETag"), err
+}
+
+// DoPage requests a page of a paginated route, decoding it into resp. The
+// request for the following page is returned, or nil if this is the last
+// page.
+func (b *BasicClient) DoPage(ctx context.Context, req *RequestTemplate, resp interface{}) (*RequestTemplate, error) {
+	response, err := b.doResponse(ctx, req, resp)
+	if err != nil {
+		return nil, err
+	}
+	link, ok := parseLinks(response.Header["Link"])["next"]
+	if !ok {
+		return nil, nil
+	}
+	next, err := response.Request.URL.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	path := next.String()
+	if !strings.HasPrefix(path, b.url) {
+		return nil, fmt.Errorf("next page %s is not under %s", path, b.url)
+	}
+	return &RequestTemplate{
+		codec:   req.codec,
+		method:  req.method,
+		path:    path[len(b.url):],
+		headers: req.headers,
+	}, nil
+}
+
+func (b *BasicClient) doResponse(ctx context.Context, req *RequestTemplate, resp interface{}) (*http.Response, error) {
+	hr := req.Build(b.url).WithContext(ctx)
 	if b.beforeHook != nil {
 		if err := b.beforeHook(hr); err != nil {
-			return err
+			return nil, err
 		}
 	}
-	response, err := b.httpClient.Do(hr)
+	response, err := b.do(hr)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return response, ErrorForStatusWithHeaders(http.StatusNotModified, response.Header)
 	}
 	if resp == nil {
-		return nil
+		return response, nil
 	}
 	_, respi := valueAndInterface(resp)
-	return b.codec.Response(respi).DecodeResponse(response)
+	return response, b.codec.Response(respi).DecodeResponse(response)
+}
+
+// DoStreaming issues a request to a streaming route. Frames are decoded from
+// the response one at a time by calling Next() on the returned ClientStream.
+func (b *BasicClient) DoStreaming(req *RequestTemplate) (ClientStream, error) {
+	return b.DoStreamingContext(context.Background(), req)
+}
+
+// DoStreamingContext is like DoStreaming, but the stream is closed when ctx
+// is done.
+func (b *BasicClient) DoStreamingContext(ctx context.Context, req *RequestTemplate) (ClientStream, error) {
+	hr := req.Build(b.url).WithContext(ctx)
+	if b.beforeHook != nil {
+		if err := b.beforeHook(hr); err != nil {
+			return nil, err
+		}
+	}
+	response, err := b.do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		defer response.Body.Close()
+		err = b.codec.Response(nil).DecodeResponse(response)
+		if err == nil {
+			err = ErrorForStatus(response.StatusCode)
+		}
+		return nil, err
+	}
+	return &basicClientStream{
+		codec:  b.codec,
+		body:   response.Body,
+		reader: bufio.NewReader(response.Body),
+	}, nil
+}
+
+// do issues a request, advertising support for compressed responses and
+// decompressing the response body if necessary. Responses indicating that a
+// rate limit has been exceeded are returned as a *RateLimitError.
+func (b *BasicClient) do(hr *http.Request) (*http.Response, error) {
+	if hr.Header.Get("Accept-Encoding") == "" {
+		hr.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+	// Propagate the trace of the request being served, if any.
+	if sc, ok := SpanContextFromContext(hr.Context()); ok && hr.Header.Get("traceparent") == "" {
+		sc.Inject(hr.Header)
+	}
+	response, err := b.httpClient.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if err := decompressResponse(response); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	if response.StatusCode == http.StatusTooManyRequests {
+		defer response.Body.Close()
+		return nil, newRateLimitError(response, b.codec.Response(nil).DecodeResponse(response))
+	}
+	return response, nil
+}
+
+// decompressResponse replaces the response body with a decompressing reader
+// according to its Content-Encoding.
+func decompressResponse(response *http.Response) error {
+	var body io.ReadCloser
+	var err error
+	switch strings.ToLower(response.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(response.Body)
+
+	case "deflate":
+		body, err = zlib.NewReader(response.Body)
+
+	default:
+		return nil
+	}
+	if err == io.EOF {
+		body = http.NoBody
+	} else if err != nil {
+		return err
+	}
+	response.Body = &decompressingReader{body, response.Body}
+	response.Header.Del("Content-Encoding")
+	response.Header.Del("Content-Length")
+	response.ContentLength = -1
+	response.Uncompressed = true
+	return nil
 }
 
 func (b *BasicClient) HTTPClient() *http.Client {
@@ -192,6 +410,21 @@ func (b *BasicClient) Close() error {
 	return nil
 }
 
+type basicClientStream struct {
+	codec  CodecFactory
+	body   io.ReadCloser
+	reader *bufio.Reader
+}
+
+func (b *basicClientStream) Next(v interface{}) error {
+	_, vi := valueAndInterface(v)
+	return b.codec.Stream(vi).DecodeFrame(b.reader)
+}
+
+func (b *basicClientStream) Close() error {
+	return b.body.Close()
+}
+
 // type RetryingClient struct {
 // 	client  Client
 // 	backoff backoff.BackOff
@@ -1,5 +1,14 @@
 package rapid
 
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
 // func TestClient(t *testing.T) {
 // 	svc := Define("Test")
 // 	svc.Route("Index").Get("/").Request(&indexRequest{}).Response(&indexResponse{})
@@ -7,3 +16,38 @@ package rapid
 // 	err := GenerateClient("test", "github.com/alecthomas/rapid", svc, buf)
 // 	assert.NoError(t, err)
 // }
+
+func TestClientDoStreaming(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/{id}").Get().Responses(Response(200, &indexResponse{}).Streaming())
+	svr, _ := NewServer(svc.Build(), &testChunkedServer{})
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+	stream, err := client.DoStreaming(Request(nil, "GET", "/{id}", 1).Build())
+	assert.NoError(t, err)
+	defer stream.Close()
+
+	resp := &indexResponse{}
+	assert.NoError(t, stream.Next(resp))
+	assert.Equal(t, 1, resp.ID)
+	err = stream.Next(resp)
+	assert.Equal(t, &HTTPStatus{Status: http.StatusGatewayTimeout, Message: "timed out", Headers: http.Header{}}, err)
+	assert.NoError(t, stream.Next(resp))
+	assert.Equal(t, 2, resp.ID)
+	assert.Equal(t, io.EOF, stream.Next(resp))
+}
+
+func TestClientDoStreamingError(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/{id}").Get().Responses(Response(200, &indexResponse{}).Streaming())
+	svr, _ := NewServer(svc.Build(), &testChunkedServer{})
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	client, _ := Dial(DefaultCodecFactory, ts.URL)
+	_, err := client.DoStreaming(Request(nil, "POST", "/{id}", 1).Build())
+	assert.Equal(t, http.StatusMethodNotAllowed, err.(*HTTPStatus).Status)
+}
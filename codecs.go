@@ -1,6 +1,7 @@
 package rapid
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"io"
@@ -31,6 +32,17 @@ type Codec interface {
 	ResponseCodec
 }
 
+// Encoding and decoding individual frames of a streaming response on the
+// server and client, respectively.
+type StreamCodec interface {
+	// Encode a single frame into w. If err is non-nil, an in-band error frame
+	// is encoded instead of the value.
+	EncodeFrame(w io.Writer, err error) error
+	// Decode a single frame from r. In-band error frames are returned as
+	// errors.
+	DecodeFrame(r *bufio.Reader) error
+}
+
 type panicResponseCodec struct {
 	RequestCodec
 }
@@ -65,7 +77,17 @@ func (p *panicRequestCodec) DecodeRequest(r *http.Request) error {
 
 // ErrorResponse is the wire-format for a RAPID error response.
 type ErrorResponse struct {
-	Error string `json:"e,omitempty"`
+	Error     string        `json:"e,omitempty"`
+	Fields    []*FieldError `json:"fields,omitempty"`     // Set for *ValidationError.
+	RequestID RequestID     `json:"request_id,omitempty"` // Set if the server has an access log.
+}
+
+// StreamFrame is the wire-format for a single frame of a RAPID streaming
+// response.
+type StreamFrame struct {
+	Data   interface{} `json:"d,omitempty"`
+	Error  string      `json:"e,omitempty"`
+	Status int         `json:"s,omitempty"`
 }
 
 // A CodecFactory is a function that
@@ -97,6 +119,23 @@ func (c CodecFactory) Codec(v interface{}) Codec {
 	return c(v)
 }
 
+// Return v if it conforms to StreamCodec, otherwise use CodecFactory to
+// encode/decode v. If the Codec returned by the factory does not support
+// streaming, frames are encoded as newline-delimited JSON.
+func (c CodecFactory) Stream(v interface{}) StreamCodec {
+	if c, ok := v.(StreamCodec); ok {
+		return c
+	}
+	codec := c(v)
+	if w, ok := codec.(*codecWrapper); ok {
+		codec = w.Codec
+	}
+	if c, ok := codec.(StreamCodec); ok {
+		return c
+	}
+	return &defaultCodec{v}
+}
+
 // Default, JSON codec.
 type defaultCodec struct {
 	v interface{}
@@ -128,7 +167,14 @@ func (d *defaultCodec) EncodeResponse(r *http.Request, w http.ResponseWriter, st
 	w.WriteHeader(status)
 	data := d.v
 	if err != nil && (status < 200 || status > 299) {
-		data = &ErrorResponse{Error: err.Error()}
+		response := &ErrorResponse{Error: err.Error()}
+		if v, ok := err.(*ValidationError); ok {
+			response.Fields = v.Fields
+		}
+		if r != nil {
+			response.RequestID = RequestIDFromContext(r.Context())
+		}
+		data = response
 	}
 	return json.NewEncoder(w).Encode(data)
 }
@@ -140,12 +186,41 @@ func (d *defaultCodec) DecodeResponse(r *http.Response) error {
 			// Not a valid response structure, return error.
 			return Error(http.StatusInternalServerError, err.Error())
 		}
+		if len(response.Fields) > 0 {
+			return &ValidationError{Fields: response.Fields}
+		}
 		// Use error in response structure.
-		return Error(r.StatusCode, response.Error)
+		status := Error(r.StatusCode, response.Error).(*HTTPStatus)
+		status.RequestID = response.RequestID
+		return status
 	}
 	return json.NewDecoder(r.Body).Decode(d.v)
 }
 
+func (d *defaultCodec) EncodeFrame(w io.Writer, err error) error {
+	frame := &StreamFrame{Data: d.v}
+	if err != nil {
+		status, err := inferStatus(nil, 0, err)
+		frame = &StreamFrame{Error: err.Error(), Status: status}
+	}
+	return json.NewEncoder(w).Encode(frame)
+}
+
+func (d *defaultCodec) DecodeFrame(r *bufio.Reader) error {
+	line, err := r.ReadBytes('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return err
+	}
+	frame := &StreamFrame{Data: d.v}
+	if err := json.Unmarshal(line, frame); err != nil {
+		return err
+	}
+	if frame.Error != "" {
+		return Error(frame.Status, frame.Error)
+	}
+	return nil
+}
+
 type FileDownload struct {
 	Filename  string
 	MediaType string
@@ -216,13 +291,15 @@ func (d *RawData) EncodeRequest() (headers http.Header, body io.ReadCloser, err
 	return headers, ioutil.NopCloser(bytes.NewReader(*d)), nil
 }
 
+// DecodeRequest reads the request body into memory. The size of the body is
+// bounded by the route's MaxBodySize.
 func (d *RawData) DecodeRequest(r *http.Request) error {
 	defer r.Body.Close()
-	w := bytes.NewBuffer(nil)
-	if _, err := io.Copy(w, r.Body); err != nil {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
 		return err
 	}
-	*d = w.Bytes()
+	*d = data
 	return nil
 }
 
@@ -282,6 +359,8 @@ func inferStatus(r *http.Request, status int, err error) (int, error) {
 	// Check if it's a HTTPStatus error, in which case check the status code.
 	if st, ok := err.(*HTTPStatus); ok {
 		status = st.Status
+	} else if _, ok := err.(*ValidationError); ok {
+		status = http.StatusBadRequest
 	} else if status == 0 {
 		// If it's any other error type, set 500 and continue.
 		status = http.StatusInternalServerError
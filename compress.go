@@ -0,0 +1,258 @@
+package rapid
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultCompressibleTypes are the media types compressed by a server when
+// Compression() is not given any.
+var DefaultCompressibleTypes = []string{
+	"application/json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"text/*",
+}
+
+type compressionConfig struct {
+	minSize      int
+	contentTypes []string
+}
+
+// Compression enables compression of responses with gzip or deflate,
+// according to the request's Accept-Encoding header. Only responses of at
+// least minSize bytes with one of the given media types are compressed. Media
+// types may be wildcards such as "text/*", and default to
+// DefaultCompressibleTypes.
+//
+// Request bodies are decompressed according to their Content-Encoding
+// regardless of this setting.
+func (s *Server) Compression(minSize int, contentTypes ...string) *Server {
+	if len(contentTypes) == 0 {
+		contentTypes = DefaultCompressibleTypes
+	}
+	s.compression = &compressionConfig{minSize: minSize, contentTypes: contentTypes}
+	return s
+}
+
+func (c *compressionConfig) compressible(contentType string) bool {
+	ranges := []*mediaRange{}
+	for _, t := range c.contentTypes {
+		ranges = append(ranges, &mediaRange{mediaType: t, quality: 1})
+	}
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	return mediaType != "" && acceptQuality(ranges, mediaType) > 0
+}
+
+// negotiateEncoding returns the preferred encoding in an Accept-Encoding
+// header that the server supports, or "".
+func negotiateEncoding(header string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, _ = strconv.ParseFloat(param[2:], 64)
+			}
+		}
+		qualities[coding] = quality
+	}
+	best, bestQuality := "", 0.0
+	for _, coding := range []string{"gzip", "deflate"} {
+		quality, ok := qualities[coding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = coding, quality
+		}
+	}
+	return best
+}
+
+// decompressRequest replaces the request body with a decompressing reader
+// according to its Content-Encoding. If limit is non-zero the decompressed
+// body is limited to that many bytes.
+func decompressRequest(r *http.Request, limit int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	var err error
+	switch encoding {
+	case "", "identity":
+		return nil
+
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+
+	case "deflate":
+		body, err = zlib.NewReader(r.Body)
+
+	default:
+		return Error(http.StatusUnsupportedMediaType, "unsupported Content-Encoding "+encoding)
+	}
+	if err == io.EOF {
+		body = http.NoBody
+	} else if err != nil {
+		return Error(http.StatusBadRequest, "invalid "+encoding+" request body")
+	}
+	if limit > 0 {
+		body = http.MaxBytesReader(nil, body, limit)
+	}
+	r.Body = &decompressingReader{body, r.Body}
+	r.Header.Del("Content-Encoding")
+	r.ContentLength = -1
+	return nil
+}
+
+type decompressingReader struct {
+	io.ReadCloser
+	body io.ReadCloser
+}
+
+func (d *decompressingReader) Close() error {
+	d.ReadCloser.Close()
+	return d.body.Close()
+}
+
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressResponseWriter compresses the response body if it is large enough
+// and of a compressible type. Output is buffered until the minimum size is
+// reached, or the response is flushed or closed.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	config   *compressionConfig
+	encoding string
+	status   int
+	buffer   bytes.Buffer
+	decided  bool
+	writer   flushWriteCloser // Nil if the response is not compressed.
+	err      error            // The first error writing the response.
+}
+
+func newCompressResponseWriter(w http.ResponseWriter, config *compressionConfig, encoding string) *compressResponseWriter {
+	return &compressResponseWriter{ResponseWriter: w, config: config, encoding: encoding}
+}
+
+func (c *compressResponseWriter) WriteHeader(status int) {
+	if c.status == 0 {
+		c.status = status
+	}
+}
+
+func (c *compressResponseWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.decided {
+		return c.write(b)
+	}
+	c.buffer.Write(b)
+	if c.buffer.Len() >= c.config.minSize {
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (c *compressResponseWriter) write(b []byte) (int, error) {
+	var n int
+	if c.writer != nil {
+		n, c.err = c.writer.Write(b)
+	} else {
+		n, c.err = c.ResponseWriter.Write(b)
+	}
+	return n, c.err
+}
+
+// decide whether to compress the response, and write the header and any
+// buffered output.
+func (c *compressResponseWriter) decide(compress bool) error {
+	if c.decided {
+		return nil
+	}
+	c.decided = true
+	h := c.Header()
+	if compress && h.Get("Content-Encoding") == "" && c.config.compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		if c.encoding == "gzip" {
+			c.writer = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			c.writer = zlib.NewWriter(c.ResponseWriter)
+		}
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if c.buffer.Len() == 0 {
+		return nil
+	}
+	_, err := c.write(c.buffer.Bytes())
+	c.buffer.Reset()
+	return err
+}
+
+// Flush writes any buffered output to the client. As flushing indicates a
+// streaming response, it is compressed regardless of its size.
+//
+// Errors are returned by subsequent calls to Write and Close.
+func (c *compressResponseWriter) Flush() {
+	if c.decide(true) != nil {
+		return
+	}
+	if c.writer != nil {
+		if c.err = c.writer.Flush(); c.err != nil {
+			return
+		}
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := c.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Close completes the response. Responses smaller than the minimum size are
+// not compressed.
+func (c *compressResponseWriter) Close() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.status == 0 && c.buffer.Len() == 0 {
+		// Nothing was written.
+		return nil
+	}
+	if err := c.decide(c.buffer.Len() >= c.config.minSize); err != nil {
+		return err
+	}
+	if c.writer != nil {
+		c.err = c.writer.Close()
+	}
+	return c.err
+}
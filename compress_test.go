@@ -0,0 +1,205 @@
+package rapid
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCompressionServer struct{}
+
+func (t *testCompressionServer) List(query *indexRequest) ([]*indexResponse, error) {
+	out := []*indexResponse{}
+	for i := 0; i < query.ID; i++ {
+		out = append(out, &indexResponse{i})
+	}
+	return out, nil
+}
+
+func (t *testCompressionServer) Echo(req *indexRequest) (*indexResponse, error) {
+	return &indexResponse{req.ID}, nil
+}
+
+func (t *testCompressionServer) Stream() (chan *indexResponse, chan error) {
+	dc := make(chan *indexResponse)
+	ec := make(chan error)
+	go func() {
+		defer close(dc)
+		dc <- &indexResponse{1}
+		dc <- &indexResponse{2}
+	}()
+	return dc, ec
+}
+
+func (t *testCompressionServer) Panics(w http.ResponseWriter) {
+	w.Write([]byte("partial"))
+	panic("oops")
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	assert.NoError(t, err)
+	out, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	return string(out)
+}
+
+func TestCompressResponse(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	w := serveTestRequest(svr, "GET", "/list?ID=100", "", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Contains(t, w.Header()["Vary"], "Accept-Encoding")
+	out := []*indexResponse{}
+	assert.NoError(t, json.Unmarshal([]byte(gunzip(t, w.Body.Bytes())), &out))
+	assert.Equal(t, 100, len(out))
+}
+
+func TestCompressResponseDeflate(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	w := serveTestRequest(svr, "GET", "/list?ID=100", "", http.Header{"Accept-Encoding": {"gzip;q=0.5, deflate"}})
+	assert.Equal(t, "deflate", w.Header().Get("Content-Encoding"))
+	r, err := zlib.NewReader(w.Body)
+	assert.NoError(t, err)
+	out := []*indexResponse{}
+	assert.NoError(t, json.NewDecoder(r).Decode(&out))
+	assert.Equal(t, 100, len(out))
+}
+
+func TestCompressResponseTooSmall(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	w := serveTestRequest(svr, "GET", "/list?ID=1", "", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "[{\"ID\":0}]\n", w.Body.String())
+}
+
+func TestCompressResponseNotAccepted(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	w := serveTestRequest(svr, "GET", "/list?ID=100", "", http.Header{"Accept-Encoding": {"br, gzip;q=0"}})
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+}
+
+func TestCompressResponseNotCompressibleType(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256, "text/*")
+	w := serveTestRequest(svr, "GET", "/list?ID=100", "", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	out := []*indexResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &out))
+	assert.Equal(t, 100, len(out))
+}
+
+func TestCompressStreamingResponse(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Stream", "/stream").Get().Responses(Response(200, &indexResponse{}).Streaming())
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	w := serveTestRequest(svr, "GET", "/stream", "", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "{\"d\":{\"ID\":1}}\n{\"d\":{\"ID\":2}}\n", gunzip(t, w.Body.Bytes()))
+}
+
+func TestCompressResponsePanic(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Panics", "/panics").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256).Logger(&testLogger{})
+	w := serveTestRequest(svr, "GET", "/panics", "", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "{\"e\":\"Internal Server Error\"}\n", w.Body.String())
+}
+
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (f *failingResponseWriter) Write(b []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestCompressResponseWriteError(t *testing.T) {
+	w := newCompressResponseWriter(&failingResponseWriter{httptest.NewRecorder()}, &compressionConfig{contentTypes: DefaultCompressibleTypes}, "gzip")
+	w.Header().Set("Content-Type", "application/json")
+	w.Flush()
+	_, err := w.Write([]byte("{}"))
+	assert.EqualError(t, err, "write failed")
+	assert.EqualError(t, w.Close(), "write failed")
+}
+
+func TestDecompressRequest(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").Put().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	body := &bytes.Buffer{}
+	gz := gzip.NewWriter(body)
+	gz.Write([]byte(`{"ID":42}`))
+	gz.Close()
+	r, _ := http.NewRequest("PUT", "/echo", body)
+	r.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"ID\":42}\n", w.Body.String())
+}
+
+func TestDecompressRequestUnsupportedEncoding(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").Put().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	r, _ := http.NewRequest("PUT", "/echo", bytes.NewBufferString(`{"ID":42}`))
+	r.Header.Set("Content-Encoding", "br")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	assert.Equal(t, "gzip", negotiateEncoding("gzip, deflate"))
+	assert.Equal(t, "deflate", negotiateEncoding("deflate"))
+	assert.Equal(t, "deflate", negotiateEncoding("gzip;q=0.1, deflate;q=0.5"))
+	assert.Equal(t, "gzip", negotiateEncoding("*"))
+	assert.Equal(t, "deflate", negotiateEncoding("gzip;q=0, *"))
+	assert.Equal(t, "", negotiateEncoding("identity"))
+	assert.Equal(t, "", negotiateEncoding(""))
+}
+
+func TestClientCompression(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").Put().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svc.Route("List", "/list").Get().Query(&indexRequest{}).Response(200, []*indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testCompressionServer{}).Compression(256)
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+	resp := &indexResponse{}
+	err = client.Do(Request(nil, "PUT", "/echo").Body(&indexRequest{42}).Compress().Build(), resp)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, resp.ID)
+
+	list := []*indexResponse{}
+	err = client.Do(Request(nil, "GET", "/list").Query(&indexRequest{100}).Build(), &list)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, len(list))
+}
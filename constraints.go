@@ -0,0 +1,358 @@
+package rapid
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Constraints declared with the "validate" struct tag are enforced by the
+// server after decoding path, query and request types, and are included in
+// generated RAML. eg.
+//
+//	type ListQuery struct {
+//	  Limit int    `schema:"limit" validate:"min=1,max=100"`
+//	  Sort  string `schema:"sort" validate:"required,oneof=name|age"`
+//	  Name  string `schema:"name" validate:"pattern=^[a-z]+$"`
+//	}
+//
+// Supported constraints are:
+//
+//   - required: the field must not be the zero value.
+//   - min=n, max=n: bounds for numbers, and for the length of strings,
+//     slices and maps.
+//   - pattern=regex: strings must match regex. As regex may contain commas,
+//     pattern must be the last constraint in the tag.
+//   - oneof=a|b|c: the field must be one of the given values.
+//
+// Constraints other than required are not checked for fields that are absent
+// from the request: parameters and headers that were not sent, and nil
+// pointers, slices and maps in request bodies.
+type constraints struct {
+	required bool
+	min      *float64
+	max      *float64
+	pattern  *regexp.Regexp
+	oneOf    []string
+}
+
+func parseConstraints(tag string) (*constraints, error) {
+	c := &constraints{}
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "pattern=") {
+			item, tag = tag, ""
+		} else if i := strings.Index(tag, ","); i >= 0 {
+			item, tag = tag[:i], tag[i+1:]
+		} else {
+			item, tag = tag, ""
+		}
+		key, value := item, ""
+		if i := strings.Index(item, "="); i >= 0 {
+			key, value = item[:i], item[i+1:]
+		}
+		switch key {
+		case "required":
+			c.required = true
+
+		case "min", "max":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s constraint %q", key, value)
+			}
+			if key == "min" {
+				c.min = &n
+			} else {
+				c.max = &n
+			}
+
+		case "pattern":
+			pattern, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern constraint: %s", err)
+			}
+			c.pattern = pattern
+
+		case "oneof":
+			c.oneOf = strings.Split(value, "|")
+
+		default:
+			return nil, fmt.Errorf("unknown constraint %q", item)
+		}
+	}
+	return c, nil
+}
+
+// checkKind returns an error if the constraints can not be applied to
+// values of type t.
+func (c *constraints) checkKind(t reflect.Type) error {
+	t = indirect(t)
+	if (c.min != nil || c.max != nil) && !isNumberKind(t.Kind()) {
+		switch t.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		default:
+			return fmt.Errorf("min and max constraints are not supported for %s", t)
+		}
+	}
+	if c.pattern != nil && t.Kind() != reflect.String {
+		return fmt.Errorf("pattern constraint is not supported for %s", t)
+	}
+	return nil
+}
+
+// check returns a *FieldError without Field or Location if v violates the
+// constraints. present is false if the field was absent from the request.
+func (c *constraints) check(v reflect.Value, present bool) *FieldError {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	if isZeroValue(v) && c.required {
+		return &FieldError{Code: CodeRequired, Message: "required"}
+	}
+	if !present || isNil(v) {
+		return nil
+	}
+	if c.min != nil || c.max != nil {
+		n, length := measure(v)
+		subject := "must be"
+		if length {
+			subject = "length must be"
+		}
+		if c.min != nil && n < *c.min {
+			return &FieldError{Code: CodeMin, Message: fmt.Sprintf("%s at least %v", subject, *c.min)}
+		}
+		if c.max != nil && n > *c.max {
+			return &FieldError{Code: CodeMax, Message: fmt.Sprintf("%s at most %v", subject, *c.max)}
+		}
+	}
+	if c.pattern != nil && !c.pattern.MatchString(v.String()) {
+		return &FieldError{Code: CodePattern, Message: fmt.Sprintf("must match %s", c.pattern)}
+	}
+	if c.oneOf != nil {
+		s := fmt.Sprint(v.Interface())
+		for _, option := range c.oneOf {
+			if s == option {
+				return nil
+			}
+		}
+		return &FieldError{Code: CodeOneOf, Message: fmt.Sprintf("must be one of %s", strings.Join(c.oneOf, ", "))}
+	}
+	return nil
+}
+
+// measure returns the value of a number, or the length of anything else.
+func measure(v reflect.Value) (n float64, length bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), false
+
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false
+
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), true
+	}
+	return float64(v.Len()), true
+}
+
+func isNumberKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+type fieldConstraints struct {
+	index       int
+	name        string
+	constraints *constraints // Nil if the field has no validate tag.
+}
+
+var constraintsCache = struct {
+	sync.Mutex
+	types map[reflect.Type][]*fieldConstraints
+}{types: map[reflect.Type][]*fieldConstraints{}}
+
+// structConstraints returns the constraints declared on the fields of the
+// struct type t.
+func structConstraints(t reflect.Type) ([]*fieldConstraints, error) {
+	constraintsCache.Lock()
+	defer constraintsCache.Unlock()
+	if fields, ok := constraintsCache.types[t]; ok {
+		return fields, nil
+	}
+	fields := []*fieldConstraints{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _ := parseTag(f)
+		if isFirstLower(f.Name) || name == "" {
+			continue
+		}
+		fc := &fieldConstraints{index: i, name: name}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			c, err := parseConstraints(tag)
+			if err == nil {
+				err = c.checkKind(f.Type)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", t, f.Name, err)
+			}
+			fc.constraints = c
+		}
+		fields = append(fields, fc)
+	}
+	constraintsCache.types[t] = fields
+	return fields, nil
+}
+
+// checkConstraints returns an error if any validate tags on t, or on types
+// nested in t, are invalid.
+func checkConstraints(t reflect.Type) error {
+	return checkConstraintsWithCycles(cycleMap{}, t)
+}
+
+func checkConstraintsWithCycles(cycles cycleMap, t reflect.Type) error {
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return checkConstraintsWithCycles(cycles, t.Elem())
+
+	case reflect.Struct:
+		if cycles[t] || t == timeType {
+			return nil
+		}
+		cycles[t] = true
+		fields, err := structConstraints(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := checkConstraintsWithCycles(cycles, t.Field(f.index).Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// presence reports whether the field with the given name was present in the
+// request. A nil presence treats all fields that are not nil as present.
+type presence func(name string) bool
+
+// valuesPresence returns the presence of keys in values, such as query
+// parameters or headers. Keys are matched case-insensitively, as they are
+// when decoding, and nested fields are present if their key is.
+func valuesPresence(values url.Values) presence {
+	return func(name string) bool {
+		name = strings.ToLower(name)
+		for key := range values {
+			key = strings.ToLower(key)
+			if key == name || strings.HasPrefix(key, name+".") || strings.HasPrefix(name, key+".") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// validateConstraints enforces the constraints declared on v, returning a
+// *ValidationError if any are violated. Types that decode themselves are not
+// validated.
+func validateConstraints(location string, v interface{}, present presence) error {
+	if _, ok := v.(RequestCodec); ok {
+		return nil
+	}
+	fields := []*FieldError{}
+	validateValue(&fields, location, "", reflect.ValueOf(v), present)
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+func validateValue(errors *[]*FieldError, location, name string, v reflect.Value, present presence) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return
+		}
+		fields, err := structConstraints(v.Type())
+		if err != nil {
+			// Checked by NewServer().
+			panic(err)
+		}
+		prefix := ""
+		if name != "" {
+			prefix = name + "."
+		}
+		for _, f := range fields {
+			fv := v.Field(f.index)
+			if f.constraints != nil {
+				if err := f.constraints.check(fv, present == nil || present(prefix+f.name)); err != nil {
+					err.Field = prefix + f.name
+					err.Location = location
+					*errors = append(*errors, err)
+					continue
+				}
+			}
+			validateValue(errors, location, prefix+f.name, fv, present)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(errors, location, fmt.Sprintf("%s[%d]", name, i), v.Index(i), nil)
+		}
+
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			validateValue(errors, location, fmt.Sprintf("%s[%v]", name, key.Interface()), v.MapIndex(key), nil)
+		}
+	}
+}
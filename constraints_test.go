@@ -0,0 +1,149 @@
+package rapid
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/jsonschema"
+	"github.com/stretchr/testify/assert"
+)
+
+type constrainedQuery struct {
+	Limit int    `schema:"limit" validate:"min=1,max=100"`
+	Sort  string `schema:"sort" validate:"required,oneof=name|age"`
+	Name  string `schema:"name" validate:"min=2,pattern=^[a-z]+$"`
+}
+
+type constrainedAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type constrainedBody struct {
+	Tags      []string              `json:"tags" validate:"max=2"`
+	Addresses []*constrainedAddress `json:"addresses"`
+}
+
+type testConstraintsServer struct {
+	called bool
+}
+
+func (t *testConstraintsServer) List(query *constrainedQuery) error {
+	t.called = true
+	return nil
+}
+
+func (t *testConstraintsServer) Create(body *constrainedBody) error {
+	t.called = true
+	return nil
+}
+
+func serveTestConstraints(t *testing.T, method, path, body string) (bool, []*FieldError) {
+	svc := Define("Test")
+	svc.Route("List", "/").Get().Query(&constrainedQuery{}).Response(http.StatusOK, nil)
+	svc.Route("Create", "/").Post().Request(&constrainedBody{}).Response(http.StatusCreated, nil)
+	test := &testConstraintsServer{}
+	svr, err := NewServer(svc.Build(), test)
+	assert.NoError(t, err)
+	w := serveTestRequest(svr, method, path, body, nil)
+	response := &ErrorResponse{}
+	if w.Code == http.StatusBadRequest {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), response))
+	}
+	return test.called, response.Fields
+}
+
+func TestParseConstraints(t *testing.T) {
+	c, err := parseConstraints("required,min=1,max=2.5,oneof=a|b,pattern=^[a,b]+$")
+	assert.NoError(t, err)
+	assert.True(t, c.required)
+	assert.Equal(t, 1.0, *c.min)
+	assert.Equal(t, 2.5, *c.max)
+	assert.Equal(t, []string{"a", "b"}, c.oneOf)
+	assert.Equal(t, "^[a,b]+$", c.pattern.String())
+
+	_, err = parseConstraints("min=one")
+	assert.Error(t, err)
+	_, err = parseConstraints("maximum=1")
+	assert.Error(t, err)
+	_, err = parseConstraints("pattern=[")
+	assert.Error(t, err)
+}
+
+func TestQueryConstraints(t *testing.T) {
+	called, fields := serveTestConstraints(t, "GET", "/?sort=name&limit=10&name=bob", "")
+	assert.True(t, called)
+	assert.Nil(t, fields)
+
+	called, fields = serveTestConstraints(t, "GET", "/?limit=1000&name=B", "")
+	assert.False(t, called)
+	assert.Equal(t, []*FieldError{
+		{Field: "limit", Location: LocationQuery, Code: CodeMax, Message: "must be at most 100"},
+		{Field: "sort", Location: LocationQuery, Code: CodeRequired, Message: "required"},
+		{Field: "name", Location: LocationQuery, Code: CodeMin, Message: "length must be at least 2"},
+	}, fields)
+
+	// Bounds apply to zero values that are present, but not to absent ones.
+	called, fields = serveTestConstraints(t, "GET", "/?sort=name&limit=0", "")
+	assert.False(t, called)
+	assert.Equal(t, []*FieldError{
+		{Field: "limit", Location: LocationQuery, Code: CodeMin, Message: "must be at least 1"},
+	}, fields)
+	called, _ = serveTestConstraints(t, "GET", "/?sort=name", "")
+	assert.True(t, called)
+
+	_, fields = serveTestConstraints(t, "GET", "/?sort=size&name=B0B", "")
+	assert.Equal(t, []*FieldError{
+		{Field: "sort", Location: LocationQuery, Code: CodeOneOf, Message: "must be one of name, age"},
+		{Field: "name", Location: LocationQuery, Code: CodePattern, Message: "must match ^[a-z]+$"},
+	}, fields)
+}
+
+func TestBodyConstraints(t *testing.T) {
+	called, _ := serveTestConstraints(t, "POST", "/", `{"tags": ["a"], "addresses": [{"city": "Sydney"}]}`)
+	assert.True(t, called)
+
+	called, fields := serveTestConstraints(t, "POST", "/", `{"tags": ["a", "b", "c"], "addresses": [{"city": "Sydney"}, {}]}`)
+	assert.False(t, called)
+	assert.Equal(t, []*FieldError{
+		{Field: "tags", Location: LocationBody, Code: CodeMax, Message: "length must be at most 2"},
+		{Field: "addresses[1].city", Location: LocationBody, Code: CodeRequired, Message: "required"},
+	}, fields)
+}
+
+type invalidConstraintsQuery struct {
+	Enabled bool `schema:"enabled" validate:"min=1"`
+}
+
+func (t *testConstraintsServer) Invalid(query *invalidConstraintsQuery) error {
+	return nil
+}
+
+func TestServerRejectsInvalidConstraints(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Invalid", "/").Get().Query(&invalidConstraintsQuery{}).Response(http.StatusOK, nil)
+	_, err := NewServer(svc.Build(), &testConstraintsServer{})
+	assert.EqualError(t, err, "route Invalid: rapid.invalidConstraintsQuery.Enabled: min and max constraints are not supported for bool")
+}
+
+func TestConstraintsToRAML(t *testing.T) {
+	params := structToRAMLParams(reflect.TypeOf(&constrainedQuery{}), false)
+	assert.Equal(t, rmap{
+		"limit": rmap{"type": "integer", "minimum": 1.0, "maximum": 100.0},
+		"sort":  rmap{"type": "string", "required": true, "enum": []string{"name", "age"}},
+		"name":  rmap{"type": "string", "minLength": 2, "pattern": "^[a-z]+$"},
+	}, params)
+
+	schema, err := marshalJSONSchema(jsonschema.ReflectFromType(reflect.TypeOf(&constrainedBody{})), reflect.TypeOf(&constrainedBody{}))
+	assert.NoError(t, err)
+	doc := struct {
+		Definitions map[string]struct {
+			Required   []string
+			Properties map[string]map[string]interface{}
+		}
+	}{}
+	assert.NoError(t, json.Unmarshal([]byte(schema), &doc))
+	assert.Equal(t, 2.0, doc.Definitions["constrainedBody"].Properties["tags"]["maxItems"])
+	assert.Contains(t, doc.Definitions["constrainedAddress"].Required, "city")
+}
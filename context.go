@@ -0,0 +1,57 @@
+package rapid
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type contextKey int
+
+const (
+	routeContextKey contextKey = iota
+	paramsContextKey
+	principalContextKey
+	spanContextKey
+	requestIDContextKey
+)
+
+// RouteFromContext returns the RouteSchema matched for the request that ctx
+// was injected into, or nil.
+func RouteFromContext(ctx context.Context) *RouteSchema {
+	route, _ := ctx.Value(routeContextKey).(*RouteSchema)
+	return route
+}
+
+// ParamsFromContext returns the path parameters matched for the request that
+// ctx was injected into, or nil.
+func ParamsFromContext(ctx context.Context) Params {
+	params, _ := ctx.Value(paramsContextKey).(Params)
+	return params
+}
+
+// PrincipalFromContext returns the principal authenticated for the request
+// that ctx was injected into, or nil.
+func PrincipalFromContext(ctx context.Context) Principal {
+	return ctx.Value(principalContextKey)
+}
+
+// Timeout sets the maximum duration of a request, after which the context
+// injected into its handler is cancelled. Streaming and WebSocket routes are
+// not subject to the timeout.
+func (s *Server) Timeout(timeout time.Duration) *Server {
+	s.timeout = timeout
+	return s
+}
+
+// requestContext derives the context for a matched request. The returned
+// function must be called when the request completes.
+func (s *Server) requestContext(r *http.Request, match *routeMatch, params Params) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(r.Context(), routeContextKey, match.route)
+	ctx = context.WithValue(ctx, paramsContextKey, params)
+	response := match.route.DefaultResponse()
+	if s.timeout == 0 || match.route.WebSocket != nil || response != nil && response.Streaming {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
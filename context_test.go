@@ -0,0 +1,96 @@
+package rapid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testContextServer struct {
+	route  *RouteSchema
+	params Params
+	err    error
+}
+
+func (t *testContextServer) Get(ctx context.Context) (*indexResponse, error) {
+	t.route = RouteFromContext(ctx)
+	t.params = ParamsFromContext(ctx)
+	return &indexResponse{1}, nil
+}
+
+func (t *testContextServer) Wait(ctx context.Context) error {
+	<-ctx.Done()
+	t.err = ctx.Err()
+	return ErrorForStatus(http.StatusServiceUnavailable)
+}
+
+func TestServerInjectsContext(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	test := &testContextServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	r, _ := http.NewRequest("GET", "/users/10", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Get", test.route.Name)
+	assert.Equal(t, Params{"id": "10"}, test.params)
+}
+
+func TestServerTimeoutCancelsContext(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Wait", "/wait").Get().Response(200, nil)
+	test := &testContextServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	svr.Timeout(time.Millisecond * 10)
+	r, _ := http.NewRequest("GET", "/wait", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, context.DeadlineExceeded, test.err)
+}
+
+func TestServerClientDisconnectCancelsContext(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Wait", "/wait").Get().Response(200, nil)
+	test := &testContextServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _ := http.NewRequest("GET", "/wait", nil)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		cancel()
+	}()
+	svr.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+	assert.Equal(t, context.Canceled, test.err)
+}
+
+func TestClientDoContext(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	svc.Route("Wait", "/wait").Get().Response(200, nil)
+	test := &testContextServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+	resp := &indexResponse{}
+	err = client.DoContext(context.Background(), Request(nil, "GET", "/users/{id}", 1).Build(), resp)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, resp.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	err = client.DoContext(ctx, Request(nil, "GET", "/wait").Build(), nil)
+	assert.Error(t, err)
+
+	// Closing the test server waits for the handler to observe the disconnect.
+	ts.Close()
+	assert.Equal(t, context.Canceled, test.err)
+}
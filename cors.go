@@ -0,0 +1,136 @@
+package rapid
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header
+// for a request from origin, or "" if the origin is not allowed.
+func (c *CORSSchema) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowOrigins {
+		if allowed == "*" {
+			return "*"
+		}
+		if strings.EqualFold(allowed, origin) {
+			return origin
+		}
+	}
+	return ""
+}
+
+// checkCORS panics if policy allows credentials from any origin, which
+// browsers forbid.
+func checkCORS(policy *CORSSchema, owner string) {
+	if policy == nil || !policy.AllowCredentials {
+		return
+	}
+	for _, allowed := range policy.AllowOrigins {
+		if allowed == "*" {
+			panic(fmt.Sprintf("CORS policy for %s allows credentials from any origin", owner))
+		}
+	}
+}
+
+// resolveCORS returns the most specific policy applying to a route.
+func resolveCORS(schema *Schema, resource *ResourceSchema, route *RouteSchema) *CORSSchema {
+	switch {
+	case route.CORS != nil:
+		return route.CORS
+	case resource.CORS != nil:
+		return resource.CORS
+	}
+	return schema.CORS
+}
+
+// applyCORS adds response headers for a cross-origin request to a route with
+// the given policy.
+func applyCORS(policy *CORSSchema, w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if policy == nil || origin == "" {
+		return
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	allowed := policy.allowOrigin(origin)
+	if allowed == "" {
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowed)
+	if policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(policy.ExposeHeaders) > 0 {
+		h.Set("Access-Control-Expose-Headers", strings.Join(policy.ExposeHeaders, ", "))
+	}
+}
+
+// servePreflight answers a CORS preflight request for a route with a CORS
+// policy. It returns false if the request is not a preflight request or is
+// not allowed by the policy, in which case it should be handled normally.
+func (s *Server) servePreflight(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != "OPTIONS" || origin == "" || method == "" {
+		return false
+	}
+	match, _ := s.router.match(method, r.URL.Path)
+	if match == nil {
+		match, _ = s.router.match(AnyMethod, r.URL.Path)
+	}
+	if match == nil || match.cors == nil {
+		return false
+	}
+	policy := match.cors
+	allowed := policy.allowOrigin(origin)
+	if allowed == "" {
+		return false
+	}
+	methods := policy.AllowMethods
+	if len(methods) == 0 {
+		methods = s.corsMethods(r.URL.Path)
+	}
+	if !containsString(methods, method) {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	h.Set("Access-Control-Allow-Origin", allowed)
+	h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(policy.AllowHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(policy.AllowHeaders, ", "))
+	} else if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		h.Set("Access-Control-Allow-Headers", headers)
+	}
+	if policy.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if policy.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// corsMethods returns the methods of the routes matching path, which
+// cross-origin requests may use by default.
+func (s *Server) corsMethods(path string) []string {
+	methods := []string{}
+	for _, method := range s.router.methods(path) {
+		if method != "OPTIONS" {
+			methods = append(methods, method)
+		}
+	}
+	if match, _ := s.router.match(AnyMethod, path); match != nil {
+		for _, method := range anyMethods {
+			if !containsString(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods
+}
@@ -0,0 +1,105 @@
+package rapid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCORSServer struct{}
+
+func (t *testCORSServer) Get() (*indexResponse, error)    { return &indexResponse{1}, nil }
+func (t *testCORSServer) Delete() error                   { return nil }
+func (t *testCORSServer) Public() (*indexResponse, error) { return &indexResponse{2}, nil }
+
+func TestCORSPreflight(t *testing.T) {
+	svc := Define("Test").CORS(CORS("https://app.example.com").Credentials().ExposeHeaders("X-Total"))
+	users := svc.Resource("Users", "/users").CORS(CORS("https://admin.example.com").Methods("GET", "DELETE").MaxAge(time.Hour))
+	users.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	users.Route("Delete", "/users/{id}").Delete()
+	svr := newTestServer(t, svc.Build(), &testCORSServer{})
+	r, _ := http.NewRequest("OPTIONS", "/users/1", nil)
+	r.Header.Set("Origin", "https://admin.example.com")
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	r.Header.Set("Access-Control-Request-Headers", "X-Token")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "https://admin.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "X-Token", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "3600", w.Header().Get("Access-Control-Max-Age"))
+}
+
+func TestCORSPreflightDisallowedOrigin(t *testing.T) {
+	svc := Define("Test").CORS(CORS("https://app.example.com").Credentials().ExposeHeaders("X-Total"))
+	users := svc.Resource("Users", "/users").CORS(CORS("https://admin.example.com").Methods("GET", "DELETE").MaxAge(time.Hour))
+	users.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	users.Route("Delete", "/users/{id}").Delete()
+	svr := newTestServer(t, svc.Build(), &testCORSServer{})
+	r, _ := http.NewRequest("OPTIONS", "/users/1", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+}
+
+func TestCORSActualRequest(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Public", "/public").Get().Response(200, &indexResponse{}).CORS(CORS("*"))
+	svr := newTestServer(t, svc.Build(), &testCORSServer{})
+	r, _ := http.NewRequest("GET", "/public", nil)
+	r.Header.Set("Origin", "https://other.example.com")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+}
+
+func TestCORSPreflightDefaultMethods(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Public", "/public").Get().Response(200, &indexResponse{}).CORS(CORS("*"))
+	svr := newTestServer(t, svc.Build(), &testCORSServer{})
+	r, _ := http.NewRequest("OPTIONS", "/public", nil)
+	r.Header.Set("Origin", "https://other.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Access-Control-Allow-Methods"))
+
+	// Methods not defined for the path are not allowed.
+	r.Header.Set("Access-Control-Request-Method", "DELETE")
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestCORSResolvesDefinitionPolicy(t *testing.T) {
+	schema := Define("Test").CORS(CORS("*"))
+	schema.Route("Index", "/").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, schema.Build(), &testServer{})
+	r, _ := http.NewRequest("OPTIONS", "/", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestCORSRejectsWildcardCredentials(t *testing.T) {
+	schema := Define("Test").CORS(CORS("*").Credentials())
+	schema.Route("Index", "/").Get().Response(200, &indexResponse{})
+	assert.Panics(t, func() { schema.Build() })
+
+	schema = Define("Test")
+	schema.Route("Index", "/").Get().Response(200, &indexResponse{}).CORS(CORS("https://app.example.com", "*").Credentials())
+	assert.Panics(t, func() { schema.Build() })
+}
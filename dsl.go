@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"reflect"
 	"strings"
+	"time"
 )
 
 type definition struct {
@@ -37,6 +38,27 @@ func (d *definition) Version(version string) *definition {
 	return d
 }
 
+// MediaTypes declares the media types supported for request and response
+// bodies, for documentation. The first is the default.
+func (d *definition) MediaTypes(mediaTypes ...string) *definition {
+	d.model.MediaTypes = mediaTypes
+	return d
+}
+
+// MaxBodySize sets the default maximum size of request bodies, in bytes.
+// Negative values remove the limit. Defaults to DefaultMaxBodySize, except
+// for file uploads, which are unlimited by default.
+func (d *definition) MaxBodySize(n int64) *definition {
+	d.model.MaxBodySize = n
+	return d
+}
+
+// CORS sets the default Cross-Origin Resource Sharing policy for all routes.
+func (d *definition) CORS(policy *cors) *definition {
+	d.model.CORS = policy.model
+	return d
+}
+
 func (d *definition) Resource(name, path string) *resource {
 	r := &resource{&ResourceSchema{
 		Name: name,
@@ -69,8 +91,11 @@ seek:
 
 // Build a RAPID
 func (d *definition) Build() *Schema {
+	checkCORS(d.model.CORS, d.model.Name)
 	for _, resource := range d.model.Resources {
+		checkCORS(resource.CORS, resource.Name)
 		for _, route := range resource.Routes {
+			checkCORS(route.CORS, route.String())
 			if route.Method == "" {
 				panic(fmt.Sprintf("route %s has not specified a HTTP method", route.Name))
 			}
@@ -80,6 +105,21 @@ func (d *definition) Build() *Schema {
 			if !strings.HasPrefix(route.Path, resource.Path) {
 				panic(fmt.Sprintf("route %s is not under resource %s", route, resource.Path))
 			}
+			if len(route.Scopes) > 0 && len(route.SecuredBy) == 0 {
+				panic(fmt.Sprintf("route %s requires scopes but is not secured", route))
+			}
+			if route.WebSocket != nil {
+				if route.Method != "GET" {
+					panic(fmt.Sprintf("WebSocket route %s must use GET", route))
+				}
+				if len(route.Responses) == 0 {
+					route.Responses = append(route.Responses, &ResponseSchema{
+						Status:      http.StatusSwitchingProtocols,
+						Description: "Upgrade to WebSocket.",
+					})
+				}
+				continue
+			}
 			// Check if different 200 responses have different response types. This is not supported.
 			successful := false
 			var okType reflect.Type
@@ -92,13 +132,15 @@ func (d *definition) Build() *Schema {
 					successful = true
 				}
 			}
+			if route.Pagination != nil && (okType == nil || indirect(okType).Kind() != reflect.Slice) {
+				panic(fmt.Sprintf("paginated route %s must respond with a slice", route))
+			}
 			if !successful {
 				if route.Method == "GET" {
 					panic(fmt.Sprintf("no successful responses defined for %s", route))
 				}
 				route.Responses = append(route.Responses, &ResponseSchema{
-					Status:      http.StatusNoContent,
-					ContentType: "application/json",
+					Status: http.StatusNoContent,
 				})
 			}
 		}
@@ -116,6 +158,13 @@ func (r *resource) Description(description string) *resource {
 	return r
 }
 
+// CORS sets the Cross-Origin Resource Sharing policy for routes in this
+// resource.
+func (r *resource) CORS(policy *cors) *resource {
+	r.model.CORS = policy.model
+	return r
+}
+
 // Route adds a new route to this resource.
 func (r *resource) Route(name, path string) *route {
 	rt := newRoute(name, path)
@@ -141,9 +190,11 @@ func (r *route) Method(method string) *route {
 	return r
 }
 
-// Any matches any HTTP method.
+// Any matches any HTTP method. Routes with explicit methods take precedence
+// over Any routes. The handler can accept a RequestMethod to determine the
+// method of the request.
 func (r *route) Any() *route {
-	return r.Method("")
+	return r.Method(AnyMethod)
 }
 
 func (r *route) Post() *route {
@@ -191,6 +242,15 @@ func (r *route) Query(query interface{}) *route {
 	return r
 }
 
+// Header sets the type used to decode a request's headers. Each header is
+// decoded into the field with the corresponding "header" tag, eg.
+// `header:"X-Request-Priority"`, or the field of the same name. Headers are
+// validated in the same way as query parameters.
+func (r *route) Header(headers interface{}) *route {
+	r.model.HeaderType = reflect.TypeOf(headers)
+	return r
+}
+
 // Path sets the type used to decode a request's path parameters. Each
 // parameter is deserialized into the corresponding parameter using
 // gorilla/
@@ -204,6 +264,56 @@ func (r *route) Request(req interface{}) *route {
 	return r
 }
 
+// WebSocket defines this route as a WebSocket endpoint. Messages sent by the
+// client are decoded into values of the same type as in, and values of the
+// same type as out are sent to the client.
+//
+// The handler receives the messages as typed channels, eg.
+//
+//	func (s *Service) Chat(in <-chan *Message, out chan<- *Message) error
+//
+// The connection is closed when the handler returns.
+func (r *route) WebSocket(in, out interface{}) *route {
+	r.model.WebSocket = &WebSocketSchema{
+		InType:  reflect.TypeOf(in),
+		OutType: reflect.TypeOf(out),
+	}
+	return r.Method("GET")
+}
+
+// MaxBodySize sets the maximum size of request bodies for this route, in
+// bytes, overriding the service default. Negative values remove the limit.
+// Larger requests receive a 413 response.
+func (r *route) MaxBodySize(n int64) *route {
+	r.model.MaxBodySize = n
+	return r
+}
+
+// RateLimit limits each caller of this route to rate requests per second,
+// with bursts of up to burst requests, overriding the server default. A
+// negative rate removes the limit. Callers exceeding the limit receive a 429
+// response.
+func (r *route) RateLimit(rate float64, burst int) *route {
+	r.model.RateLimit = &RateLimitSchema{Rate: rate, Burst: burst}
+	return r
+}
+
+// Paginated defines this route as returning a slice of items one page at a
+// time. In addition to its query parameters, the route accepts "limit" and
+// either "cursor" or "offset" query parameters according to style, which are
+// decoded into a *Page injected into the handler.
+//
+// The handler should return (<response>, <next cursor>, <error>), and links
+// to other pages are sent to the client in a Link header.
+func (r *route) Paginated(style PaginationStyle) *route {
+	r.model.Pagination = &PaginationSchema{
+		Style:        style,
+		DefaultLimit: DefaultPageLimit,
+		MaxLimit:     MaxPageLimit,
+	}
+	return r
+}
+
 // FileUpload specifies that this route is a multipart form file upload.
 func (r *route) FileUpload() *route {
 	r.model.FileUpload = true
@@ -233,6 +343,20 @@ func (r *route) SecuredBy(names ...string) *route {
 	return r
 }
 
+// Scopes requires callers of this secured route to have been granted all of
+// scopes, eg. by the "scope" claim of a JWT. Callers without them receive a
+// 403 response. The route must also be secured with SecuredBy().
+func (r *route) Scopes(scopes ...string) *route {
+	r.model.Scopes = append(r.model.Scopes, scopes...)
+	return r
+}
+
+// CORS sets the Cross-Origin Resource Sharing policy for this route.
+func (r *route) CORS(policy *cors) *route {
+	r.model.CORS = policy.model
+	return r
+}
+
 type response struct {
 	model *ResponseSchema
 }
@@ -244,9 +368,8 @@ func Response(status int, typ interface{}) *response {
 	}
 	return &response{
 		&ResponseSchema{
-			Status:      status,
-			Type:        t,
-			ContentType: "application/json",
+			Status: status,
+			Type:   t,
 		},
 	}
 }
@@ -256,12 +379,63 @@ func (r *response) Description(description string) *response {
 	return r
 }
 
+// ContentType restricts the response to a single media type. By default the
+// media type is negotiated from the codecs registered with the server.
 func (r *response) ContentType(ct string) *response {
 	r.model.ContentType = ct
 	return r
 }
 
+// Streaming marks the response as a stream of values. The handler for the
+// route should return (chan <type>, chan error), and each value is sent to
+// the client as a separate frame. Unless otherwise specified, frames are
+// newline-delimited JSON.
 func (r *response) Streaming() *response {
 	r.model.Streaming = true
+	if r.model.ContentType == "" {
+		r.model.ContentType = "application/x-ndjson"
+	}
 	return r
 }
+
+type cors struct {
+	model *CORSSchema
+}
+
+// CORS creates a Cross-Origin Resource Sharing policy allowing requests from
+// the given origins. Use "*" to allow any origin. A policy allowing any origin
+// can not allow credentials.
+func CORS(origins ...string) *cors {
+	return &cors{&CORSSchema{AllowOrigins: origins}}
+}
+
+// Methods that cross-origin requests may use.
+func (c *cors) Methods(methods ...string) *cors {
+	c.model.AllowMethods = append(c.model.AllowMethods, methods...)
+	return c
+}
+
+// Headers that cross-origin requests may include.
+func (c *cors) Headers(headers ...string) *cors {
+	c.model.AllowHeaders = append(c.model.AllowHeaders, headers...)
+	return c
+}
+
+// ExposeHeaders lists response headers that cross-origin clients may read.
+func (c *cors) ExposeHeaders(headers ...string) *cors {
+	c.model.ExposeHeaders = append(c.model.ExposeHeaders, headers...)
+	return c
+}
+
+// Credentials allows cross-origin requests to include credentials. Origins
+// must be listed explicitly.
+func (c *cors) Credentials() *cors {
+	c.model.AllowCredentials = true
+	return c
+}
+
+// MaxAge sets how long clients may cache the result of a preflight request.
+func (c *cors) MaxAge(age time.Duration) *cors {
+	c.model.MaxAge = age
+	return c
+}
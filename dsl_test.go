@@ -25,3 +25,12 @@ func TestDefineDSLViaResources(t *testing.T) {
 	some.Route("DeleteSome", "/some/{id}").Delete()
 	assert.Equal(t, 2, len(d.model.ResourceByPath("/some").Routes))
 }
+
+func TestBuildAnyRoute(t *testing.T) {
+	d := Define("Test")
+	d.Route("Any", "/any").Any()
+	schema := d.Build()
+	route := schema.RouteByName("Any")
+	assert.True(t, route.IsAny())
+	assert.Equal(t, 204, route.DefaultResponse().Status)
+}
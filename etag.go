@@ -0,0 +1,163 @@
+package rapid
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// An ETagger is a response type that knows its entity version. The server
+// sends the value in the ETag header of responses, and answers GET and HEAD
+// requests with a matching If-None-Match header with 304 Not Modified.
+//
+// The value is quoted if it is not already, eg. "v1" is sent as "\"v1\"".
+// Handlers may instead return an ETag header with ErrorWithHeaders().
+type ETagger interface {
+	ETag() string
+}
+
+// A LastModifier is a response type that knows when it was last modified.
+// The server sends the time in the Last-Modified header of responses, and
+// answers GET and HEAD requests with an If-Modified-Since header at or after
+// it with 304 Not Modified.
+type LastModifier interface {
+	LastModified() time.Time
+}
+
+// Preconditions are the conditional headers of a request. They are injected
+// into handlers, which can enforce them before modifying a resource. eg.
+//
+//	func (u *UsersService) UpdateUser(pre *rapid.Preconditions, params rapid.Params, user *User) error {
+//	  current := u.users[params["id"]]
+//	  if err := pre.Check(current.ETag(), current.Modified); err != nil {
+//	    return err
+//	  }
+//	  ...
+//	}
+type Preconditions struct {
+	IfMatch           []string  // Entity tags from If-Match, or nil.
+	IfUnmodifiedSince time.Time // Zero if absent.
+}
+
+func newPreconditions(r *http.Request) *Preconditions {
+	p := &Preconditions{IfMatch: parseETags(r.Header.Get("If-Match"))}
+	if t, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil {
+		p.IfUnmodifiedSince = t
+	}
+	return p
+}
+
+// Check returns a 412 Precondition Failed error if the current version of a
+// resource does not satisfy the preconditions. etag is the current entity
+// tag of the resource, or "" if it does not exist. lastModified may be zero
+// if unknown.
+func (p *Preconditions) Check(etag string, lastModified time.Time) error {
+	if p.IfMatch != nil {
+		if !matchETag(p.IfMatch, etag, false) {
+			return ErrorForStatus(http.StatusPreconditionFailed)
+		}
+	} else if !p.IfUnmodifiedSince.IsZero() && !lastModified.IsZero() {
+		if lastModified.Truncate(time.Second).After(p.IfUnmodifiedSince) {
+			return ErrorForStatus(http.StatusPreconditionFailed)
+		}
+	}
+	return nil
+}
+
+// quoteETag quotes an entity tag if it is not already quoted.
+func quoteETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// parseETags parses a comma separated list of entity tags, or returns nil if
+// header is empty.
+func parseETags(header string) []string {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+	etags := []string{}
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// matchETag returns true if etag matches any of etags. Weak comparison
+// ignores the W/ prefix, while strong comparison never matches weak tags.
+func matchETag(etags []string, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	etag = quoteETag(etag)
+	for _, candidate := range etags {
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEntityHeaders sets the ETag and Last-Modified headers of successful
+// responses from their data, and returns true if the request is conditional
+// and the client's copy is not modified. In that case a 304 response has been
+// sent.
+//
+// Headers returned by the handler in an *HTTPStatus take precedence.
+func writeEntityHeaders(w http.ResponseWriter, r *http.Request, data interface{}, err error) bool {
+	if status, _ := inferStatus(r, 0, err); status < 200 || status > 299 {
+		return false
+	}
+	returned := http.Header{}
+	if e, ok := err.(*HTTPStatus); ok && e.Headers != nil {
+		returned = e.Headers
+	}
+	headers := w.Header()
+	etag := returned.Get("ETag")
+	if e, ok := data.(ETagger); ok && etag == "" {
+		if etag = quoteETag(e.ETag()); etag != "" {
+			headers.Set("ETag", etag)
+		}
+	}
+	lastModified := returned.Get("Last-Modified")
+	if l, ok := data.(LastModifier); ok && lastModified == "" {
+		if t := l.LastModified(); !t.IsZero() {
+			lastModified = t.UTC().Format(http.TimeFormat)
+			headers.Set("Last-Modified", lastModified)
+		}
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+	if !notModified(r, etag, lastModified) {
+		return false
+	}
+	for key, values := range returned {
+		headers[key] = values
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if inm := parseETags(r.Header.Get("If-None-Match")); inm != nil {
+		return matchETag(inm, etag, true)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
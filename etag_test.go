@@ -0,0 +1,161 @@
+package rapid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testModified = time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type versionedResponse struct {
+	Version int
+}
+
+func (v *versionedResponse) ETag() string {
+	return strconv.Itoa(v.Version)
+}
+
+func (v *versionedResponse) LastModified() time.Time {
+	return testModified
+}
+
+type testETagServer struct {
+	version int
+}
+
+func (t *testETagServer) Get() (*versionedResponse, error) {
+	return &versionedResponse{t.version}, nil
+}
+
+func (t *testETagServer) Update(pre *Preconditions) (*versionedResponse, error) {
+	if err := pre.Check(strconv.Itoa(t.version), testModified); err != nil {
+		return nil, err
+	}
+	t.version++
+	return &versionedResponse{t.version}, nil
+}
+
+func (t *testETagServer) Headers() (*indexResponse, error) {
+	return &indexResponse{1}, ErrorForStatusWithHeaders(http.StatusOK, http.Header{"Etag": {`"abc"`}})
+}
+
+func TestETagResponseHeaders(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/").Get().Response(200, &versionedResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	w := serveTestRequest(svr, "GET", "/", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "Sat, 02 Jan 2016 03:04:05 GMT", w.Header().Get("Last-Modified"))
+	assert.Equal(t, "{\"Version\":1}\n", w.Body.String())
+}
+
+func TestETagIfNoneMatch(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/").Get().Response(200, &versionedResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	w := serveTestRequest(svr, "GET", "/", "", http.Header{"If-None-Match": {`"0", W/"1"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	assert.Equal(t, "", w.Body.String())
+
+	w = serveTestRequest(svr, "GET", "/", "", http.Header{"If-None-Match": {`"0"`}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"Version\":1}\n", w.Body.String())
+}
+
+func TestETagIfModifiedSince(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/").Get().Response(200, &versionedResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	w := serveTestRequest(svr, "GET", "/", "", http.Header{"If-Modified-Since": {"Sat, 02 Jan 2016 03:04:05 GMT"}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serveTestRequest(svr, "GET", "/", "", http.Header{"If-Modified-Since": {"Fri, 01 Jan 2016 03:04:05 GMT"}})
+	assert.Equal(t, 200, w.Code)
+
+	// If-None-Match takes precedence.
+	w = serveTestRequest(svr, "GET", "/", "", http.Header{
+		"If-Modified-Since": {"Sat, 02 Jan 2016 03:04:05 GMT"},
+		"If-None-Match":     {`"0"`},
+	})
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestETagReturnedHeader(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Headers", "/headers").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	w := serveTestRequest(svr, "GET", "/headers", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, []string{`"abc"`}, w.Header()["Etag"])
+
+	w = serveTestRequest(svr, "GET", "/headers", "", http.Header{"If-None-Match": {`"abc"`}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+}
+
+func TestPreconditionsIfMatch(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Update", "/").Put().Response(200, &versionedResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	w := serveTestRequest(svr, "PUT", "/", "", http.Header{"If-Match": {`"0"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveTestRequest(svr, "PUT", "/", "", http.Header{"If-Match": {`"1"`}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	w = serveTestRequest(svr, "PUT", "/", "", http.Header{"If-Match": {`W/"2"`}})
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+
+	w = serveTestRequest(svr, "PUT", "/", "", http.Header{"If-Match": {"*"}})
+	assert.Equal(t, 200, w.Code)
+
+	w = serveTestRequest(svr, "PUT", "/", "", nil)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestPreconditionsIfUnmodifiedSince(t *testing.T) {
+	pre := &Preconditions{IfUnmodifiedSince: testModified.Add(-time.Second)}
+	assert.Equal(t, ErrorForStatus(http.StatusPreconditionFailed), pre.Check("1", testModified))
+	pre = &Preconditions{IfUnmodifiedSince: testModified}
+	assert.NoError(t, pre.Check("1", testModified.Add(time.Millisecond)))
+}
+
+func TestClientETag(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/").Get().Response(200, &versionedResponse{})
+	svc.Route("Update", "/").Put().Response(200, &versionedResponse{})
+	svr := newTestServer(t, svc.Build(), &testETagServer{version: 1})
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	resp := &versionedResponse{}
+	etag, err := client.DoETag(ctx, Request(nil, "GET", "/").Build(), resp)
+	assert.NoError(t, err)
+	assert.Equal(t, `"1"`, etag)
+	assert.Equal(t, 1, resp.Version)
+
+	resp = &versionedResponse{}
+	etag, err = client.DoETag(ctx, Request(nil, "GET", "/").IfNoneMatch(etag).Build(), resp)
+	assert.Equal(t, http.StatusNotModified, err.(*HTTPStatus).Status)
+	assert.Equal(t, `"1"`, etag)
+	assert.Equal(t, 0, resp.Version)
+
+	etag, err = client.DoETag(ctx, Request(nil, "PUT", "/").IfMatch("1").Build(), resp)
+	assert.NoError(t, err)
+	assert.Equal(t, `"2"`, etag)
+
+	err = client.Do(Request(nil, "PUT", "/").IfMatch("1").Build(), resp)
+	assert.Equal(t, http.StatusPreconditionFailed, err.(*HTTPStatus).Status)
+}
@@ -1,8 +1,10 @@
 package example
 
 import (
+	"context"
 	"net/http"
 	"path"
+	"sort"
 	"time"
 
 	"github.com/alecthomas/go-logging"
@@ -29,11 +31,12 @@ func UserServiceDefinition() *rapid.Schema {
 	users.Route("ListUsers", "/users").
 		Get().
 		Response(http.StatusOK, []*User{}).
-		Description("Retrieve a list of known users.").Query(&UsersQuery{})
-	// users.Route("Changes", "/users/changes").
-	// 	Get().
-	// 	Responses(rapid.Response(http.StatusOK, 0).Streaming()).
-	// 	Description("A streaming response of change IDs.")
+		Description("Retrieve a list of known users.").Query(&UsersQuery{}).
+		Paginated(rapid.OffsetPagination)
+	users.Route("Changes", "/users/changes").
+		Get().
+		Responses(rapid.Response(http.StatusOK, 0).Streaming()).
+		Description("A streaming response of change IDs.")
 	users.Route("GetUser", "/users/{username}").
 		Get().
 		Response(http.StatusOK, &User{}).
@@ -72,8 +75,8 @@ func (u *UsersQuery) Fix() {
 	}
 }
 
-func (u *UserService) ListUsers(query *UsersQuery) ([]*User, error) {
-	log.Infof("ListUsers(%#v)", query)
+func (u *UserService) ListUsers(query *UsersQuery, page *rapid.Page) ([]*User, string, error) {
+	log.Infof("ListUsers(%#v, %#v)", query, page)
 	users := make([]*User, 0, len(u.users))
 	query.Fix()
 
@@ -83,9 +86,23 @@ func (u *UserService) ListUsers(query *UsersQuery) ([]*User, error) {
 			users = append(users, user)
 		}
 	}
-	return users, nil
+	sort.Sort(usersByID(users))
+	if page.Offset >= len(users) {
+		return []*User{}, "", nil
+	}
+	users = users[page.Offset:]
+	if len(users) > page.Limit {
+		users = users[:page.Limit]
+	}
+	return users, page.NextOffset(len(users)), nil
 }
 
+type usersByID []*User
+
+func (u usersByID) Len() int           { return len(u) }
+func (u usersByID) Less(i, j int) bool { return u[i].ID < u[j].ID }
+func (u usersByID) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
 func (u *UserService) CreateUser(user *User) error {
 	log.Infof("CreateUser(%#v)", user)
 	user.ID = len(u.users) + 1
@@ -103,7 +120,7 @@ func (u *UserService) GetUser(path *UserPath) (*User, error) {
 }
 
 // Changes streams a sequence of integers to the client.
-func (u *UserService) Changes(cancel rapid.CloseNotifierChannel) (chan int, chan error) {
+func (u *UserService) Changes(ctx context.Context) (chan int, chan error) {
 	dc := make(chan int)
 	ec := make(chan error)
 	go func() {
@@ -115,7 +132,7 @@ func (u *UserService) Changes(cancel rapid.CloseNotifierChannel) (chan int, chan
 				err := rapid.Error(http.StatusGatewayTimeout, "timed out retrieving changes")
 				log.Warningf("Returning error %s", err)
 				ec <- err
-			case <-cancel:
+			case <-ctx.Done():
 				log.Warningf("Cancelled")
 				return
 			}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+
 	"github.com/alecthomas/rapid"
 	"github.com/alecthomas/rapid/example"
 )
@@ -26,32 +28,71 @@ func NewUsersClient(codec rapid.CodecFactory, client rapid.Client) *UsersClient
 }
 
 // CreateUser - Create a new user.
-func (a *UsersClient) CreateUser(req *example.User) error {
+func (a *UsersClient) CreateUser(ctx context.Context, req *example.User) error {
 	r := rapid.Request(a.Codec, "POST", "/users").Body(req).Build()
-	err := a.C.Do(r, nil)
+	err := a.C.DoContext(ctx, r, nil)
 	return err
 }
 
 // ListUsers - Retrieve a list of known users.
-func (a *UsersClient) ListUsers(query *example.UsersQuery) ([]*example.User, error) {
+func (a *UsersClient) ListUsers(ctx context.Context, query *example.UsersQuery) ([]*example.User, error) {
 	resp := []*example.User{}
 	r := rapid.Request(a.Codec, "GET", "/users").Query(query).Build()
-	err := a.C.Do(r, &resp)
+	err := a.C.DoContext(ctx, r, &resp)
 	return resp, err
 }
 
+type ListUsersIterator struct {
+	it *rapid.PageIterator
+}
+
+// Next returns the next item, fetching the next page if necessary. io.EOF
+// is returned after the last item.
+func (i *ListUsersIterator) Next() (*example.User, error) {
+	v := &example.User{}
+	err := i.it.Next(v)
+	return v, err
+}
+
+// ListUsersIter iterates over every page of ListUsers.
+func (a *UsersClient) ListUsersIter(ctx context.Context, query *example.UsersQuery) *ListUsersIterator {
+	r := rapid.Request(a.Codec, "GET", "/users").Query(query).Build()
+	return &ListUsersIterator{rapid.NewPageIterator(ctx, a.C, r)}
+}
+
+type ChangesStream struct {
+	stream rapid.ClientStream
+}
+
+func (s *ChangesStream) Next() (int, error) {
+	var v int
+	err := s.stream.Next(&v)
+	return v, err
+}
+
+func (s *ChangesStream) Close() error {
+	return s.stream.Close()
+}
+
+// Changes - A streaming response of change IDs.
+func (a *UsersClient) Changes(ctx context.Context) (*ChangesStream, error) {
+	r := rapid.Request(a.Codec, "GET", "/users/changes").Build()
+	stream, err := a.C.DoStreamingContext(ctx, r)
+	return &ChangesStream{stream}, err
+}
+
 // GetUser - Retrieve a single user by username.
-func (a *UsersClient) GetUser(username string) (*example.User, error) {
+func (a *UsersClient) GetUser(ctx context.Context, username string) (*example.User, error) {
 	resp := &example.User{}
 	r := rapid.Request(a.Codec, "GET", "/users/{username}", username).Build()
-	err := a.C.Do(r, resp)
+	err := a.C.DoContext(ctx, r, resp)
 	return resp, err
 }
 
 // SetUserAvatar - Set user avatar.
-func (a *UsersClient) SetUserAvatar(username string, req *rapid.FileUpload) (*example.User, error) {
+func (a *UsersClient) SetUserAvatar(ctx context.Context, username string, req *rapid.FileUpload) (*example.User, error) {
 	resp := &example.User{}
 	r := rapid.Request(a.Codec, "POST", "/users/{username}/avatar", username).Body(req).Build()
-	err := a.C.Do(r, resp)
+	err := a.C.DoContext(ctx, r, resp)
 	return resp, err
 }
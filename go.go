@@ -47,6 +47,35 @@ func {{"New"|visibility}}{{.Schema.Name}}Client(codec rapid.CodecFactory, client
 {{range .Routes}}
 {{$response := .DefaultResponse}}
 {{if not .Hidden}}
+{{if .WebSocket}}
+type {{.Name|visibility}}Conn struct {
+	conn rapid.ClientConn
+}
+
+func (c *{{.Name|visibility}}Conn) Send(v {{.WebSocket.InType|type}}) error {
+	return c.conn.Send(v)
+}
+
+func (c *{{.Name|visibility}}Conn) Receive() ({{.WebSocket.OutType|type}}, error) {
+	{{var "v" .WebSocket.OutType}}
+	err := c.conn.Next({{ref "v" .WebSocket.OutType}})
+	return v, err
+}
+
+func (c *{{.Name|visibility}}Conn) Close() error {
+	return c.conn.Close()
+}
+
+{{if .Description}}// {{.Name}} - {{.Description}}{{end}}
+func (a *{{$.Schema.Name|visibility}}Client) {{.Name}}(ctx context.Context, {{if .PathType}}{{.PathType|params}}, {{end}}{{if .QueryType}}query {{.QueryType|type}}, {{end}}{{if .HeaderType}}headers {{.HeaderType|type}}, {{end}}) (*{{.Name|visibility}}Conn, error) {
+	r := rapid.Request(a.Codec, "{{.Method}}", "{{.SimplifyPath}}", {{range .PathType|names}}{{.}},{{end}}){{if .QueryType}}.Query(query){{end}}{{if .HeaderType}}.Header(headers){{end}}.Build()
+	conn, err := a.C.DoWebSocketContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	return &{{.Name|visibility}}Conn{conn}, nil
+}
+{{else}}
 {{if $response.Streaming}}
 type {{.Name|visibility}}Stream struct {
 	stream rapid.ClientStream
@@ -63,14 +92,35 @@ func (s *{{.Name|visibility}}Stream) Close() error {
 }
 {{end}}
 {{if .Description}}// {{.Name}} - {{.Description}}{{end}}
-func (a *{{$.Schema.Name|visibility}}Client) {{.Name}}({{if .PathType}}{{.PathType|params}}, {{end}}{{if .RequestType}}req {{.RequestType|type}}, {{end}}{{if .QueryType}}query {{.QueryType|type}}, {{end}}) ({{if $response.Streaming}}*{{.Name|visibility}}Stream, {{else}}{{if $response.Type}}{{$response.Type|type}}, {{end}}{{end}}error) {
+func (a *{{$.Schema.Name|visibility}}Client) {{.Name}}(ctx context.Context, {{if .IsAny}}method string, {{end}}{{if .PathType}}{{.PathType|params}}, {{end}}{{if .RequestType}}req {{.RequestType|type}}, {{end}}{{if .QueryType}}query {{.QueryType|type}}, {{end}}{{if .HeaderType}}headers {{.HeaderType|type}}, {{end}}) ({{if $response.Streaming}}*{{.Name|visibility}}Stream, {{else}}{{if $response.Type}}{{$response.Type|type}}, {{end}}{{end}}error) {
 	{{if and (not $response.Streaming) $response.Type}}\
 	{{var "resp" $response.Type}}
 	{{end}}\
-	r := rapid.Request(a.Codec, "{{.Method}}", "{{.SimplifyPath}}", {{range .PathType|names}}{{.}},{{end}}){{if .QueryType}}.Query(query){{end}}{{if .RequestType}}.Body(req){{end}}.Build()
-	{{if $response.Streaming}}stream, err := a.C.DoStreaming({{else}}err := a.C.Do({{end}}r, {{if not $response.Streaming}}{{ref "resp" $response.Type}},{{end}})
+	r := rapid.Request(a.Codec, {{if .IsAny}}method{{else}}"{{.Method}}"{{end}}, "{{.SimplifyPath}}", {{range .PathType|names}}{{.}},{{end}}){{if .QueryType}}.Query(query){{end}}{{if .HeaderType}}.Header(headers){{end}}{{if .RequestType}}.Body(req){{end}}.Build()
+	{{if $response.Streaming}}stream, err := a.C.DoStreamingContext({{else}}err := a.C.DoContext({{end}}ctx, r, {{if not $response.Streaming}}{{ref "resp" $response.Type}},{{end}})
 	{{if $response.Streaming}}return &{{.Name|visibility}}Stream{stream}, err{{else}}{{if $response.Type}}return resp, err{{else}}return err{{end}}{{end}}
 }
+{{if .Pagination}}
+{{$item := $response.Type|elem}}
+type {{.Name|visibility}}Iterator struct {
+	it *rapid.PageIterator
+}
+
+// Next returns the next item, fetching the next page if necessary. io.EOF
+// is returned after the last item.
+func (i *{{.Name|visibility}}Iterator) Next() ({{$item|type}}, error) {
+	{{var "v" $item}}
+	err := i.it.Next({{ref "v" $item}})
+	return v, err
+}
+
+// {{.Name}}Iter iterates over every page of {{.Name}}.
+func (a *{{$.Schema.Name|visibility}}Client) {{.Name}}Iter(ctx context.Context, {{if .PathType}}{{.PathType|params}}, {{end}}{{if .QueryType}}query {{.QueryType|type}}, {{end}}{{if .HeaderType}}headers {{.HeaderType|type}}, {{end}}) *{{.Name|visibility}}Iterator {
+	r := rapid.Request(a.Codec, "{{.Method}}", "{{.SimplifyPath}}", {{range .PathType|names}}{{.}},{{end}}){{if .QueryType}}.Query(query){{end}}{{if .HeaderType}}.Header(headers){{end}}.Build()
+	return &{{.Name|visibility}}Iterator{rapid.NewPageIterator(ctx, a.C, r)}
+}
+{{end}}
+{{end}}
 {{end}}
 {{end}}
 {{end}}
@@ -260,6 +310,7 @@ type goClientContext struct {
 
 func SchemaToGoClient(schema *Schema, private bool, pkg string, w io.Writer) error {
 	imports := map[string]struct{}{
+		"context":                     struct{}{},
 		"github.com/alecthomas/rapid": struct{}{},
 	}
 	for _, t := range schema.Types() {
@@ -279,6 +330,7 @@ func SchemaToGoClient(schema *Schema, private bool, pkg string, w io.Writer) err
 		"title":       strings.Title,
 		"params":      func(t reflect.Type) string { return goPathTypeToParams(pkg, t) },
 		"names":       goPathNames,
+		"elem":        func(t reflect.Type) reflect.Type { return indirect(t).Elem() },
 		"var":         func(name string, t reflect.Type) string { return goTypeDecl(pkg, name, t) },
 		"ref":         goTypeRef,
 		"needsalloc":  func(t reflect.Type) bool { return t != nil && (t.Kind() == reflect.Ptr) },
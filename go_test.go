@@ -16,12 +16,21 @@ type TestGoQuery struct {
 	Name string
 }
 
+type TestGoHeaders struct {
+	Priority int `header:"X-Priority"`
+}
+
 func TestGo(t *testing.T) {
 	w := &bytes.Buffer{}
 	d := Define("Test")
 	users := d.Resource("Users", "/users")
 	users.Route("List", "/users").Get().Query(&TestGoQuery{}).Response(200, []*TestGoUser{})
+	users.Route("Search", "/users/search").Get().Query(&TestGoQuery{}).Paginated(CursorPagination).Response(200, []*TestGoUser{})
 	users.Route("Get", "/users/{id}").Get().Response(200, &TestGoUser{})
+	users.Route("GetWithHeaders", "/users/{id}/headers").Get().Header(&TestGoHeaders{}).Response(200, &TestGoUser{})
+	users.Route("Changes", "/users/changes").Get().Responses(Response(200, &TestGoUser{}).Streaming())
+	users.Route("Proxy", "/users/{id}/proxy").Any()
+	users.Route("Chat", "/users/{id}/chat").WebSocket(&TestGoUser{}, &TestGoUser{})
 	err := SchemaToGoClient(d.Build(), false, "main", w)
 	assert.NoError(t, err)
 	fmt.Printf("%s\n", w.String())
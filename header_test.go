@@ -0,0 +1,96 @@
+package rapid
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHeaders struct {
+	Priority int      `header:"X-Priority" validate:"min=1,max=5"`
+	Tags     []string `header:"X-Tag"`
+	Token    string   `header:"X-Token,required"`
+}
+
+type testHeaderServer struct{}
+
+func (t *testHeaderServer) Headers(headers *testHeaders) (string, error) {
+	return fmt.Sprintf("%d %v %s", headers.Priority, headers.Tags, headers.Token), nil
+}
+
+func TestHeaderDecoding(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Headers", "/headers").Get().Header(&testHeaders{}).Response(200, "")
+	svr := newTestServer(t, svc.Build(), &testHeaderServer{})
+	w := serveTestRequest(svr, "GET", "/headers", "", http.Header{
+		"X-Priority": {"2"},
+		"X-Tag":      {"a", "b"},
+		"X-Token":    {"secret"},
+		"User-Agent": {"test"},
+	})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "\"2 [a b] secret\"\n", w.Body.String())
+}
+
+func TestHeaderValidation(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Headers", "/headers").Get().Header(&testHeaders{}).Response(200, "")
+	svr := newTestServer(t, svc.Build(), &testHeaderServer{})
+	w := serveTestRequest(svr, "GET", "/headers", "", http.Header{"X-Priority": {"two"}, "X-Token": {"secret"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, `{"e":"X-Priority: expected int","fields":[{"field":"X-Priority","location":"header","code":"type","message":"expected int"}]}`+"\n", w.Body.String())
+
+	w = serveTestRequest(svr, "GET", "/headers", "", http.Header{"X-Priority": {"9"}, "X-Token": {"secret"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `{"field":"X-Priority","location":"header","code":"max"`)
+
+	w = serveTestRequest(svr, "GET", "/headers", "", http.Header{"X-Priority": {"0"}, "X-Token": {"secret"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `{"field":"X-Priority","location":"header","code":"min"`)
+
+	w = serveTestRequest(svr, "GET", "/headers", "", http.Header{"X-Token": {"secret"}})
+	assert.Equal(t, 200, w.Code)
+
+	w = serveTestRequest(svr, "GET", "/headers", "", http.Header{"X-Priority": {"1"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"location":"header","code":"required"`)
+}
+
+func TestHeaderRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Headers", "/headers").Get().Header(&testHeaders{}).Response(200, "")
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svc.Build(), w)
+	assert.NoError(t, err)
+	raml := w.String()
+	assert.Regexp(t, `headers:\n\s*X-Priority:\n\s*maximum: 5\n\s*minimum: 1\n\s*type: integer\n`, raml)
+	assert.Regexp(t, `X-Tag:\n\s*repeat: true\n\s*type: string\n`, raml)
+	assert.Regexp(t, `X-Token:\n\s*required: true\n\s*type: string\n`, raml)
+}
+
+func TestClientHeader(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Headers", "/headers").Get().Header(&testHeaders{}).Response(200, "")
+	ts := httptest.NewServer(newTestServer(t, svc.Build(), &testHeaderServer{}))
+	defer ts.Close()
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+
+	resp := ""
+	headers := &testHeaders{Priority: 3, Tags: []string{"x", "y"}, Token: "secret"}
+	err = client.Do(Request(nil, "GET", "/headers").Header(headers).Build(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, "3 [x y] secret", resp)
+
+	// Optional headers with zero values are not sent, so they are not validated.
+	err = client.Do(Request(nil, "GET", "/headers").Header(&testHeaders{Token: "secret"}).Build(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, "0 [] secret", resp)
+
+	assert.Equal(t, http.Header{"X-Priority": {"3"}, "X-Tag": {"x", "y"}, "X-Token": {"secret"}}, EncodeStructToHeader(headers))
+	assert.Equal(t, http.Header{"X-Priority": {"1"}}, EncodeStructToHeader(&testHeaders{Priority: 1}))
+}
@@ -0,0 +1,481 @@
+package rapid
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JWTAudience is the "aud" claim of a JWT, which may be a single string or an
+// array of strings.
+type JWTAudience []string
+
+func (a JWTAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *JWTAudience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = JWTAudience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// JWTClaims are the registered claims of a JWT, plus the OAuth 2.0 "scope"
+// claim. Custom claims types should embed JWTClaims.
+type JWTClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  JWTAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+	Scope     string      `json:"scope,omitempty"` // Space separated.
+}
+
+// Scopes granted by the token.
+func (c *JWTClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// String returns the subject of the token.
+func (c *JWTClaims) String() string {
+	return c.Subject
+}
+
+// JWTKeys are the keys trusted to sign JWTs. The algorithm of a token must
+// match the type of its key: HS256 for secrets, RS256 for RSA keys and ES256
+// for P-256 ECDSA keys.
+type JWTKeys struct {
+	keys map[string]interface{} // Keyed by "kid".
+	anon []interface{}          // Keys without a "kid".
+}
+
+// JWTSecret trusts JWTs signed with HS256 and secret.
+func JWTSecret(secret []byte) *JWTKeys {
+	return &JWTKeys{keys: map[string]interface{}{}, anon: []interface{}{secret}}
+}
+
+// LoadJWKS loads keys from a JSON Web Key Set file.
+func LoadJWKS(path string) (*JWTKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JSON Web Key Set. RSA, P-256 EC and symmetric ("oct")
+// keys are supported. Keys for uses other than signatures are ignored.
+func ParseJWKS(data []byte) (*JWTKeys, error) {
+	set := struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %s", err)
+	}
+	keys := &JWTKeys{keys: map[string]interface{}{}}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %s", k.Kid, err)
+		}
+		keys.add(k.Kid, key)
+	}
+	return keys, nil
+}
+
+func (k *jwk) key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeJWTBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWTBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeJWTBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWTBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeJWTBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// LoadJWTKeysPEM loads public keys or certificates from a PEM file.
+func LoadJWTKeysPEM(path string) (*JWTKeys, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWTKeysPEM(data)
+}
+
+// ParseJWTKeysPEM parses RSA or P-256 ECDSA public keys and certificates
+// from PEM data.
+func ParseJWTKeysPEM(data []byte) (*JWTKeys, error) {
+	keys := &JWTKeys{keys: map[string]interface{}{}}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+				key = cert.PublicKey
+			}
+
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM %s: %s", strings.ToLower(block.Type), err)
+		}
+		keys.add("", key)
+	}
+	if len(keys.anon) == 0 {
+		return nil, errors.New("no public keys found in PEM data")
+	}
+	return keys, nil
+}
+
+func (k *JWTKeys) add(kid string, key interface{}) {
+	if kid == "" {
+		k.anon = append(k.anon, key)
+	} else {
+		k.keys[kid] = key
+	}
+}
+
+// candidates returns the keys that may have signed a token with the given
+// "kid" header.
+func (k *JWTKeys) candidates(kid string) []interface{} {
+	if key, ok := k.keys[kid]; ok {
+		return []interface{}{key}
+	}
+	if kid != "" && len(k.anon) == 0 {
+		return nil
+	}
+	keys := append([]interface{}{}, k.anon...)
+	if kid == "" {
+		for _, key := range k.keys {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtAlgorithm returns the JWS algorithm for a signing or verification key.
+func jwtAlgorithm(key interface{}) string {
+	switch key := key.(type) {
+	case []byte:
+		return "HS256"
+
+	case *rsa.PublicKey, *rsa.PrivateKey:
+		return "RS256"
+
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P256() {
+			return "ES256"
+		}
+
+	case *ecdsa.PrivateKey:
+		if key.Curve == elliptic.P256() {
+			return "ES256"
+		}
+	}
+	return ""
+}
+
+// SignJWT issues a JWT with the given claims, signed with an HMAC secret
+// ([]byte), *rsa.PrivateKey or P-256 *ecdsa.PrivateKey. kid is included in
+// the header if not empty. It is primarily intended for tests.
+func SignJWT(claims interface{}, key interface{}, kid string) (string, error) {
+	header := &jwtHeader{Alg: jwtAlgorithm(key), Kid: kid, Typ: "JWT"}
+	if header.Alg == "" {
+		return "", fmt.Errorf("unsupported JWT signing key %T", key)
+	}
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		signature = mac.Sum(nil)
+
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, key, digest[:]); err == nil {
+			signature = make([]byte, 64)
+			r.FillBytes(signature[:32])
+			s.FillBytes(signature[32:])
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// verifyJWTSignature returns true if signature is a valid signature of input
+// by key with the algorithm alg.
+func verifyJWTSignature(alg string, key interface{}, input string, signature []byte) bool {
+	if jwtAlgorithm(key) != alg {
+		return false
+	}
+	digest := sha256.Sum256([]byte(input))
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(input))
+		return hmac.Equal(signature, mac.Sum(nil))
+
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+
+	case *ecdsa.PublicKey:
+		if len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+// JWTAuthenticator is an Authenticator for JWT bearer tokens. The principal
+// injected into handlers is a *JWTClaims, or the type set with Claims().
+type JWTAuthenticator struct {
+	keys     *JWTKeys
+	realm    string
+	issuer   string
+	audience string
+	leeway   time.Duration
+	claims   reflect.Type
+	now      func() time.Time
+}
+
+// JWTAuth creates an Authenticator accepting JWTs signed by any of keys, in
+// the Authorization header as bearer tokens. Tokens must have an "exp"
+// claim, and are rejected if they have expired or are not yet valid.
+func JWTAuth(keys *JWTKeys) *JWTAuthenticator {
+	return &JWTAuthenticator{keys: keys, now: time.Now}
+}
+
+// Realm sets the realm sent in the WWW-Authenticate header.
+func (j *JWTAuthenticator) Realm(realm string) *JWTAuthenticator {
+	j.realm = realm
+	return j
+}
+
+// Issuer requires tokens to have been issued by issuer.
+func (j *JWTAuthenticator) Issuer(issuer string) *JWTAuthenticator {
+	j.issuer = issuer
+	return j
+}
+
+// Audience requires tokens to be intended for audience.
+func (j *JWTAuthenticator) Audience(audience string) *JWTAuthenticator {
+	j.audience = audience
+	return j
+}
+
+// Leeway allows for clock skew when checking "exp" and "nbf".
+func (j *JWTAuthenticator) Leeway(leeway time.Duration) *JWTAuthenticator {
+	j.leeway = leeway
+	return j
+}
+
+// Claims decodes the claims of tokens into values of the same type as
+// prototype, which is injected into handlers instead of *JWTClaims. The type
+// should embed JWTClaims for routes requiring scopes.
+func (j *JWTAuthenticator) Claims(prototype interface{}) *JWTAuthenticator {
+	j.claims = indirect(reflect.TypeOf(prototype))
+	return j
+}
+
+func (j *JWTAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, errNoCredentials
+	}
+	return j.Verify(strings.TrimSpace(auth[7:]))
+}
+
+// Verify a token and return its claims.
+func (j *JWTAuthenticator) Verify(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+	header := &jwtHeader{}
+	if err := decodeJWTSegment(parts[0], header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed JWT signature")
+	}
+	input := parts[0] + "." + parts[1]
+	verified := false
+	for _, key := range j.keys.candidates(header.Kid) {
+		if verifyJWTSignature(header.Alg, key, input, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid JWT signature")
+	}
+	claims := &JWTClaims{}
+	if err := decodeJWTSegment(parts[1], claims); err != nil {
+		return nil, err
+	}
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+	if j.claims == nil {
+		return claims, nil
+	}
+	custom := reflect.New(j.claims).Interface()
+	if err := decodeJWTSegment(parts[1], custom); err != nil {
+		return nil, err
+	}
+	return custom, nil
+}
+
+func (j *JWTAuthenticator) validate(claims *JWTClaims) error {
+	now := j.now()
+	if claims.ExpiresAt == 0 {
+		return errors.New("JWT has no expiry")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(j.leeway)) {
+		return errors.New("JWT has expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-j.leeway)) {
+		return errors.New("JWT is not valid yet")
+	}
+	if j.issuer != "" && claims.Issuer != j.issuer {
+		return fmt.Errorf("JWT issuer %q is not trusted", claims.Issuer)
+	}
+	if j.audience != "" && !containsString(claims.Audience, j.audience) {
+		return fmt.Errorf("JWT is not intended for %q", j.audience)
+	}
+	return nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return errors.New("malformed JWT")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("malformed JWT: %s", err)
+	}
+	return nil
+}
+
+func (j *JWTAuthenticator) Challenge() string {
+	return fmt.Sprintf("Bearer realm=%q", j.realm)
+}
+
+func (j *JWTAuthenticator) Describe() *SecuritySchemeSchema {
+	return &SecuritySchemeSchema{
+		Type:        "x-jwt",
+		Description: "JWT bearer token in the Authorization header.",
+		Headers:     []string{"Authorization"},
+	}
+}
@@ -0,0 +1,237 @@
+package rapid
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testJWTClaims struct {
+	JWTClaims
+	Name string `json:"name"`
+}
+
+type testJWTServer struct{}
+
+func (t *testJWTServer) Read(claims *JWTClaims) (*indexResponse, error) {
+	return &indexResponse{len(claims.Subject)}, nil
+}
+
+func (t *testJWTServer) Write(claims *JWTClaims) (*indexResponse, error) {
+	return &indexResponse{len(claims.Scopes())}, nil
+}
+
+func testJWTClaimsFor(subject, scope string) *JWTClaims {
+	return &JWTClaims{
+		Issuer:    "issuer",
+		Subject:   subject,
+		Audience:  JWTAudience{"api"},
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Scope:     scope,
+	}
+}
+
+func TestJWTAlgorithms(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	secret := []byte("secret")
+
+	for name, keys := range map[string]struct {
+		signing interface{}
+		keys    *JWTKeys
+	}{
+		"HS256": {secret, JWTSecret(secret)},
+		"RS256": {rsaKey, &JWTKeys{keys: map[string]interface{}{}, anon: []interface{}{&rsaKey.PublicKey}}},
+		"ES256": {ecKey, &JWTKeys{keys: map[string]interface{}{}, anon: []interface{}{&ecKey.PublicKey}}},
+	} {
+		token, err := SignJWT(testJWTClaimsFor("alice", ""), keys.signing, "")
+		assert.NoError(t, err, name)
+		principal, err := JWTAuth(keys.keys).Verify(token)
+		assert.NoError(t, err, name)
+		assert.Equal(t, "alice", principal.(*JWTClaims).Subject, name)
+	}
+
+	// Tokens must be signed with the algorithm of the key.
+	token, err := SignJWT(testJWTClaimsFor("alice", ""), secret, "")
+	assert.NoError(t, err)
+	_, err = JWTAuth(&JWTKeys{anon: []interface{}{&rsaKey.PublicKey}}).Verify(token)
+	assert.Error(t, err)
+	_, err = JWTAuth(JWTSecret([]byte("wrong"))).Verify(token)
+	assert.Error(t, err)
+}
+
+func TestJWTValidation(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Unix(1000, 0)
+	auth := JWTAuth(JWTSecret(secret)).Issuer("issuer").Audience("api").Leeway(time.Second)
+	auth.now = func() time.Time { return now }
+
+	tests := []struct {
+		name   string
+		claims interface{}
+		valid  bool
+	}{
+		{"Valid", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"other", "api"}, ExpiresAt: 1000}, true},
+		{"Leeway", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"api"}, ExpiresAt: 1000, NotBefore: 1001}, true},
+		{"Expired", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"api"}, ExpiresAt: 998}, false},
+		{"NoExpiry", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"api"}}, false},
+		{"NotBefore", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"api"}, ExpiresAt: 2000, NotBefore: 1002}, false},
+		{"Issuer", &JWTClaims{Issuer: "other", Audience: JWTAudience{"api"}, ExpiresAt: 2000}, false},
+		{"Audience", &JWTClaims{Issuer: "issuer", Audience: JWTAudience{"other"}, ExpiresAt: 2000}, false},
+		{"StringAudience", map[string]interface{}{"iss": "issuer", "aud": "api", "exp": 2000}, true},
+	}
+	for _, test := range tests {
+		token, err := SignJWT(test.claims, secret, "")
+		assert.NoError(t, err, test.name)
+		_, err = auth.Verify(token)
+		assert.Equal(t, test.valid, err == nil, "%s: %v", test.name, err)
+	}
+
+	_, err := auth.Verify("not.a.token")
+	assert.Error(t, err)
+}
+
+func TestJWTCustomClaims(t *testing.T) {
+	secret := []byte("secret")
+	token, err := SignJWT(&testJWTClaims{*testJWTClaimsFor("alice", "a b"), "Alice"}, secret, "")
+	assert.NoError(t, err)
+	principal, err := JWTAuth(JWTSecret(secret)).Claims(&testJWTClaims{}).Verify(token)
+	assert.NoError(t, err)
+	claims := principal.(*testJWTClaims)
+	assert.Equal(t, "Alice", claims.Name)
+	assert.Equal(t, []string{"a", "b"}, claims.Scopes())
+}
+
+func encodeTestJWKBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func TestParseJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encodeTestJWKBigInt(rsaKey.N), "e": encodeTestJWKBigInt(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encodeTestJWKBigInt(ecKey.X), "y": encodeTestJWKBigInt(ecKey.Y)},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQ", "e": "AQ"},
+		},
+	})
+	assert.NoError(t, err)
+	keys, err := ParseJWKS(jwks)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(keys.keys))
+	auth := JWTAuth(keys)
+
+	token, err := SignJWT(testJWTClaimsFor("alice", ""), rsaKey, "rsa")
+	assert.NoError(t, err)
+	_, err = auth.Verify(token)
+	assert.NoError(t, err)
+
+	token, err = SignJWT(testJWTClaimsFor("alice", ""), ecKey, "ec")
+	assert.NoError(t, err)
+	_, err = auth.Verify(token)
+	assert.NoError(t, err)
+
+	// The "kid" header selects the key.
+	token, err = SignJWT(testJWTClaimsFor("alice", ""), ecKey, "rsa")
+	assert.NoError(t, err)
+	_, err = auth.Verify(token)
+	assert.Error(t, err)
+
+	_, err = ParseJWKS([]byte(`{"keys": [{"kty": "EC", "crv": "P-384"}]}`))
+	assert.Error(t, err)
+}
+
+func TestParseJWTKeysPEM(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&ecKey.PublicKey)
+	assert.NoError(t, err)
+	keys, err := ParseJWTKeysPEM(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	assert.NoError(t, err)
+
+	token, err := SignJWT(testJWTClaimsFor("alice", ""), ecKey, "")
+	assert.NoError(t, err)
+	_, err = JWTAuth(keys).Verify(token)
+	assert.NoError(t, err)
+
+	_, err = ParseJWTKeysPEM([]byte("garbage"))
+	assert.Error(t, err)
+}
+
+func TestJWTScopes(t *testing.T) {
+	secret := []byte("secret")
+	svc := Define("Test")
+	svc.Route("Read", "/users").Get().SecuredBy("jwt").Response(200, &indexResponse{})
+	svc.Route("Write", "/users").Post().SecuredBy("jwt").Scopes("users:write").Response(201, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testJWTServer{}).SecurityScheme("jwt", JWTAuth(JWTSecret(secret)).Realm("test"))
+
+	w := serveTestRequest(svr, "GET", "/users", "", http.Header{"Authorization": {"Bearer invalid"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	token, err := SignJWT(testJWTClaimsFor("alice", "users:read"), secret, "")
+	assert.NoError(t, err)
+	w = serveTestRequest(svr, "GET", "/users", "", http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"ID\":5}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "POST", "/users", "", http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, fmt.Sprintf("{\"e\":%q}\n", `missing scope "users:write"`), w.Body.String())
+
+	token, err = SignJWT(testJWTClaimsFor("alice", "users:read users:write"), secret, "")
+	assert.NoError(t, err)
+	w = serveTestRequest(svr, "POST", "/users", "", http.Header{"Authorization": {"Bearer " + token}})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "{\"ID\":2}\n", w.Body.String())
+}
+
+func TestJWTScopesRequireSecurity(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Write", "/users").Post().Scopes("users:write").Response(201, &indexResponse{})
+	assert.Panics(t, func() { svc.Build() })
+
+	// Schemas not built with the DSL fail closed.
+	svc = Define("Test")
+	svc.Route("Write", "/users").Post().SecuredBy("jwt").Scopes("users:write").Response(201, &indexResponse{})
+	schema := svc.Build()
+	for _, resource := range schema.Resources {
+		for _, route := range resource.Routes {
+			route.SecuredBy = nil
+		}
+	}
+	svr := newTestServer(t, schema, &testJWTServer{})
+	w := serveTestRequest(svr, "POST", "/users", "", http.Header{"Authorization": {"Bearer "}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestJWTRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Read", "/users").Get().SecuredBy("jwt").Response(200, &indexResponse{})
+	svc.Route("Write", "/users").Post().SecuredBy("jwt").Scopes("users:write").Response(201, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testJWTServer{}).SecurityScheme("jwt", JWTAuth(JWTSecret([]byte("secret"))))
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svr.schema, w)
+	assert.NoError(t, err)
+	raml := w.String()
+	assert.Contains(t, raml, "type: x-jwt\n")
+	assert.Regexp(t, `scopes:\n\s*- users:write\n`, raml)
+	assert.Contains(t, raml, "Caller lacks the required scopes: users:write.")
+}
@@ -0,0 +1,289 @@
+package rapid
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of
+// request latency histograms.
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets are the upper bounds, in bytes, of the buckets of request
+// and response size histograms.
+var DefaultSizeBuckets = []float64{100, 1000, 10000, 100000, 1000000, 10000000}
+
+// Methods recorded as is in metrics. Others are recorded as "OTHER", to
+// bound the number of series.
+var metricsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// Metrics records the requests served by a Server, and exposes them in the
+// Prometheus text format when served over HTTP, eg. at /metrics.
+//
+// Series are labelled with the name of the route, rather than the request
+// path, so their number is bounded. Requests not matching a route have an
+// empty route label.
+type Metrics struct {
+	namespace      string
+	latencyBuckets []float64
+	sizeBuckets    []float64
+
+	lock     sync.Mutex
+	routes   map[metricsKey]*routeMetrics
+	inFlight map[string]int64
+}
+
+type metricsKey struct {
+	route  string
+	method string
+}
+
+type routeMetrics struct {
+	requests     map[int]uint64 // By status.
+	latency      *histogram
+	requestSize  *histogram
+	responseSize *histogram
+}
+
+// NewMetrics creates a Metrics recorder. Metric names are prefixed with
+// namespace, which defaults to "rapid".
+func NewMetrics(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = "rapid"
+	}
+	return &Metrics{
+		namespace:      namespace,
+		latencyBuckets: DefaultLatencyBuckets,
+		sizeBuckets:    DefaultSizeBuckets,
+		routes:         map[metricsKey]*routeMetrics{},
+		inFlight:       map[string]int64{},
+	}
+}
+
+// LatencyBuckets sets the upper bounds, in seconds, of the buckets of the
+// request latency histogram. It must be called before any requests are
+// recorded.
+func (m *Metrics) LatencyBuckets(buckets ...float64) *Metrics {
+	m.latencyBuckets = sortedBuckets(buckets)
+	return m
+}
+
+// SizeBuckets sets the upper bounds, in bytes, of the buckets of the request
+// and response size histograms. It must be called before any requests are
+// recorded.
+func (m *Metrics) SizeBuckets(buckets ...float64) *Metrics {
+	m.sizeBuckets = sortedBuckets(buckets)
+	return m
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// Metrics records the requests served by the server with metrics, which
+// should be served separately, eg. with http.Handle("/metrics", metrics).
+func (s *Server) Metrics(metrics *Metrics) *Server {
+	s.metrics = metrics
+	return s
+}
+
+// begin measuring a request, whose response is written to w. end() must be
+// called once the request has been served.
+func (m *Metrics) begin(w *recordingResponseWriter, r *http.Request) *requestMetrics {
+	method := r.Method
+	if !containsString(metricsMethods, method) {
+		method = "OTHER"
+	}
+	mw := &requestMetrics{metrics: m, w: w, method: method, start: time.Now()}
+	if r.Body != nil && r.ContentLength < 0 {
+		mw.body = &countingReader{ReadCloser: r.Body}
+		r.Body = mw.body
+	} else if r.ContentLength > 0 {
+		mw.requestSize = r.ContentLength
+	}
+	return mw
+}
+
+func (m *Metrics) route(route string, method string) *routeMetrics {
+	key := metricsKey{route, method}
+	rm, ok := m.routes[key]
+	if !ok {
+		rm = &routeMetrics{
+			requests:     map[int]uint64{},
+			latency:      newHistogram(m.latencyBuckets),
+			requestSize:  newHistogram(m.sizeBuckets),
+			responseSize: newHistogram(m.sizeBuckets),
+		}
+		m.routes[key] = rm
+	}
+	return rm
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	keys := make([]metricsKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	out := &strings.Builder{}
+	name := func(metric, help, typ string) string {
+		metric = m.namespace + "_" + metric
+		fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", metric, help, metric, typ)
+		return metric
+	}
+
+	metric := name("requests_total", "Requests served, by route, method and status.", "counter")
+	for _, key := range keys {
+		requests := m.routes[key].requests
+		statuses := make([]int, 0, len(requests))
+		for status := range requests {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(out, "%s{%s,status=\"%d\"} %d\n", metric, key.labels(), status, requests[status])
+		}
+	}
+
+	metric = name("requests_in_flight", "Requests currently being served, by route.", "gauge")
+	routes := make([]string, 0, len(m.inFlight))
+	for route := range m.inFlight {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		fmt.Fprintf(out, "%s{route=%s} %d\n", metric, strconv.Quote(route), m.inFlight[route])
+	}
+
+	histograms := []struct {
+		metric, help string
+		histogram    func(rm *routeMetrics) *histogram
+	}{
+		{"request_duration_seconds", "Request latency in seconds, by route and method.", func(rm *routeMetrics) *histogram { return rm.latency }},
+		{"request_size_bytes", "Request body size in bytes, by route and method.", func(rm *routeMetrics) *histogram { return rm.requestSize }},
+		{"response_size_bytes", "Response body size in bytes, by route and method.", func(rm *routeMetrics) *histogram { return rm.responseSize }},
+	}
+	for _, h := range histograms {
+		metric = name(h.metric, h.help, "histogram")
+		for _, key := range keys {
+			h.histogram(m.routes[key]).write(out, metric, key.labels())
+		}
+	}
+
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+func (k metricsKey) labels() string {
+	return fmt.Sprintf("route=%s,method=%s", strconv.Quote(k.route), strconv.Quote(k.method))
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64 // Per bucket, with a final bucket for +Inf.
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.counts[sort.SearchFloat64s(h.bounds, v)]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, metric, labels string) {
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", metric, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", metric, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", metric, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", metric, labels, h.count)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.ReadCloser.Read(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// requestMetrics measures a single request.
+type requestMetrics struct {
+	metrics     *Metrics
+	w           *recordingResponseWriter
+	route       string
+	method      string
+	matched     bool
+	start       time.Time
+	body        *countingReader // Nil if the request size is known.
+	requestSize int64
+}
+
+// match records that the request has been matched to a route, and is in
+// flight.
+func (m *requestMetrics) match(route *RouteSchema) {
+	m.route = route.Name
+	m.matched = true
+	m.metrics.lock.Lock()
+	m.metrics.inFlight[m.route]++
+	m.metrics.lock.Unlock()
+}
+
+// end records the request.
+func (m *requestMetrics) end() {
+	latency := time.Since(m.start).Seconds()
+	if m.body != nil {
+		m.requestSize = m.body.n
+	}
+	m.metrics.lock.Lock()
+	defer m.metrics.lock.Unlock()
+	if m.matched {
+		m.metrics.inFlight[m.route]--
+	}
+	rm := m.metrics.route(m.route, m.method)
+	rm.requests[m.w.Status()]++
+	rm.latency.observe(latency)
+	rm.requestSize.observe(float64(m.requestSize))
+	rm.responseSize.observe(float64(m.w.size))
+}
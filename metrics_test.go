@@ -0,0 +1,77 @@
+package rapid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMetricsPath struct {
+	ID int
+}
+
+type testMetricsServer struct{}
+
+func (t *testMetricsServer) GetItem(path *testMetricsPath) (*indexResponse, error) {
+	if path.ID == 0 {
+		return nil, ErrorForStatus(http.StatusNotFound)
+	}
+	return &indexResponse{path.ID}, nil
+}
+
+func (t *testMetricsServer) CreateItem(req *indexRequest) (*indexResponse, error) {
+	return &indexResponse{req.ID}, nil
+}
+
+func TestMetrics(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("GetItem", "/items/{ID}").Get().Path(&testMetricsPath{}).Response(200, &indexResponse{})
+	svc.Route("CreateItem", "/items").Post().Request(&indexRequest{}).Response(201, &indexResponse{})
+	metrics := NewMetrics("").LatencyBuckets(10, 1)
+	svr := newTestServer(t, svc.Build(), &testMetricsServer{}).Metrics(metrics)
+	serveTestRequest(svr, "GET", "/items/1", "", nil)
+	serveTestRequest(svr, "GET", "/items/2", "", nil)
+	serveTestRequest(svr, "GET", "/items/0", "", nil)
+	serveTestRequest(svr, "POST", "/items", `{"ID": 3}`, nil)
+	serveTestRequest(svr, "GET", "/missing", "", nil)
+	serveTestRequest(svr, "BREW", "/items/1", "", nil)
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, nil)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	out := w.Body.String()
+	assert.Contains(t, out, "# TYPE rapid_requests_total counter\n")
+	assert.Contains(t, out, `rapid_requests_total{route="GetItem",method="GET",status="200"} 2`+"\n")
+	assert.Contains(t, out, `rapid_requests_total{route="GetItem",method="GET",status="404"} 1`+"\n")
+	assert.Contains(t, out, `rapid_requests_total{route="CreateItem",method="POST",status="201"} 1`+"\n")
+	assert.Contains(t, out, `rapid_requests_total{route="",method="GET",status="404"} 1`+"\n")
+	assert.Contains(t, out, `rapid_requests_total{route="",method="OTHER",status="405"} 1`+"\n")
+	assert.Contains(t, out, `rapid_requests_in_flight{route="GetItem"} 0`+"\n")
+	assert.NotContains(t, out, "/items")
+
+	assert.Contains(t, out, "# TYPE rapid_request_duration_seconds histogram\n")
+	assert.Contains(t, out, `rapid_request_duration_seconds_bucket{route="GetItem",method="GET",le="1"} 3`+"\n")
+	assert.Contains(t, out, `rapid_request_duration_seconds_bucket{route="GetItem",method="GET",le="10"} 3`+"\n")
+	assert.Contains(t, out, `rapid_request_duration_seconds_bucket{route="GetItem",method="GET",le="+Inf"} 3`+"\n")
+	assert.Contains(t, out, `rapid_request_duration_seconds_count{route="GetItem",method="GET"} 3`+"\n")
+
+	assert.Contains(t, out, `rapid_request_size_bytes_sum{route="CreateItem",method="POST"} 9`+"\n")
+	assert.Contains(t, out, `rapid_response_size_bytes_sum{route="CreateItem",method="POST"} 9`+"\n")
+	assert.Contains(t, out, `rapid_response_size_bytes_bucket{route="CreateItem",method="POST",le="100"} 1`+"\n")
+}
+
+func TestMetricsInFlight(t *testing.T) {
+	metrics := NewMetrics("test")
+	mw := metrics.begin(newRecordingResponseWriter(httptest.NewRecorder()), httptest.NewRequest("GET", "/items/1", nil))
+	mw.match(&RouteSchema{Name: "GetItem"})
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, nil)
+	assert.Contains(t, w.Body.String(), `test_requests_in_flight{route="GetItem"} 1`+"\n")
+	mw.end()
+	w = httptest.NewRecorder()
+	metrics.ServeHTTP(w, nil)
+	assert.Contains(t, w.Body.String(), `test_requests_in_flight{route="GetItem"} 0`+"\n")
+	assert.Contains(t, w.Body.String(), `test_requests_total{route="GetItem",method="GET",status="200"} 1`+"\n")
+}
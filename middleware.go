@@ -0,0 +1,248 @@
+package rapid
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/codegangsta/inject"
+)
+
+// A Handler calls a route's handler method, injecting arguments from i, and
+// returns its response and error.
+//
+// Handler methods that write their own response produce an opaque response
+// value, which middleware must return unchanged.
+type Handler func(i inject.Injector) (response interface{}, err error)
+
+// A Middleware wraps the Handler for a route.
+//
+// Middleware is called once for each route it applies to when the server is
+// configured, so it can specialise itself for the route. The returned Handler
+// is called for each request and may:
+//
+//   - Call next with a child injector to inject additional values into the
+//     handler method.
+//   - Return an error without calling next to short-circuit the request. The
+//     error is encoded with the server's codec.
+//   - Inspect or replace the response and error returned by next before they
+//     are encoded.
+type Middleware func(route *RouteSchema, next Handler) Handler
+
+type handledResponse struct {
+	name string
+}
+
+// responseHandled is returned by a Handler when the handler method has
+// written its own response.
+var responseHandled = &handledResponse{"handled"}
+
+// streamResponse is returned by a Handler when the handler method returns a
+// channel of values.
+type streamResponse struct {
+	data   reflect.Value
+	errors reflect.Value // A channel of errors, or the zero Value.
+}
+
+// Use adds middleware applied to every route. Server middleware wraps
+// resource and route middleware, and earlier middleware wraps later
+// middleware.
+func (s *Server) Use(middleware ...Middleware) *Server {
+	s.middleware = append(s.middleware, middleware...)
+	s.buildHandlers()
+	return s
+}
+
+// UseForResource adds middleware applied to every route in the named
+// resource.
+func (s *Server) UseForResource(name string, middleware ...Middleware) error {
+	if s.schema.ResourceByName(name) == nil {
+		return fmt.Errorf("no such resource %s", name)
+	}
+	s.resourceMiddleware[name] = append(s.resourceMiddleware[name], middleware...)
+	s.buildHandlers()
+	return nil
+}
+
+// UseForRoute adds middleware applied to the named route.
+func (s *Server) UseForRoute(name string, middleware ...Middleware) error {
+	if s.schema.RouteByName(name) == nil {
+		return fmt.Errorf("no such route %s", name)
+	}
+	s.routeMiddleware[name] = append(s.routeMiddleware[name], middleware...)
+	s.buildHandlers()
+	return nil
+}
+
+// buildHandlers composes the middleware chain for every route.
+func (s *Server) buildHandlers() {
+	for _, match := range s.matches {
+		chain := []Middleware{}
+		chain = append(chain, s.middleware...)
+		chain = append(chain, s.resourceMiddleware[match.resource.Name]...)
+		chain = append(chain, s.routeMiddleware[match.route.Name]...)
+		handler := s.invoker(match)
+		for i := len(chain) - 1; i >= 0; i-- {
+			handler = chain[i](match.route, handler)
+		}
+		match.handler = handler
+	}
+}
+
+var (
+	responseWriterType = reflect.TypeOf((*http.ResponseWriter)(nil)).Elem()
+	requestType        = reflect.TypeOf((*http.Request)(nil))
+)
+
+// invoker creates the innermost Handler for a route, which calls the handler
+// method and normalises its return values.
+func (s *Server) invoker(match *routeMatch) Handler {
+	return func(i inject.Injector) (interface{}, error) {
+		if match.route.WebSocket != nil {
+			w := i.Get(responseWriterType).Interface().(http.ResponseWriter)
+			r := i.Get(requestType).Interface().(*http.Request)
+			s.handleWebSocket(match, i, w, r)
+			return responseHandled, nil
+		}
+
+		result, err := i.Invoke(match.method.Interface())
+		if err != nil {
+			// Arguments that can not be injected, eg. values expected from
+			// middleware that was not applied.
+			s.log.Errorf("%s: %s", match.route.Name, err)
+			return nil, ErrorForStatus(http.StatusInternalServerError)
+		}
+		switch len(result) {
+		case 0: // Zero return values, we assume the handler has processed the request itself.
+			return responseHandled, nil
+
+		case 1: // Single value is always an error.
+			return nil, errorValue(result[0])
+
+		case 3: // (response, next cursor, error) for paginated routes.
+			if page, ok := i.Get(pageType).Interface().(*Page); ok {
+				page.next = result[1].String()
+			}
+			return responseValue(result[0]), errorValue(result[2])
+
+		default: // (response, error) or (chan response, chan error)
+			if result[0].Kind() == reflect.Chan {
+				if result[1].Kind() != reflect.Chan {
+					if err := errorValue(result[1]); err != nil {
+						return nil, err
+					}
+					return &streamResponse{data: result[0]}, nil
+				}
+				return &streamResponse{data: result[0], errors: result[1]}, nil
+			}
+			return responseValue(result[0]), errorValue(result[1])
+		}
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// checkHandlerMethod checks that the return values of a handler method can
+// be handled by invoker.
+func checkHandlerMethod(route *RouteSchema, t reflect.Type) error {
+	switch {
+	case t.NumOut() == 0:
+		return nil
+
+	case t.NumOut() == 1 && t.Out(0).Implements(errorType):
+		return nil
+
+	case t.NumOut() == 2 && route.WebSocket == nil && t.Out(1).Implements(errorType):
+		return nil
+
+	case t.NumOut() == 2 && route.WebSocket == nil && t.Out(0).Kind() == reflect.Chan &&
+		t.Out(1).Kind() == reflect.Chan && t.Out(1).Elem().Implements(errorType):
+		return nil
+
+	case t.NumOut() == 3 && route.Pagination != nil && t.Out(1).Kind() == reflect.String && t.Out(2).Implements(errorType):
+		return nil
+	}
+	if route.WebSocket != nil {
+		return fmt.Errorf("should return nothing or <error>")
+	}
+	if route.Pagination != nil {
+		return fmt.Errorf("should return (<response>, <next cursor>, <error>)")
+	}
+	return fmt.Errorf("should return (<response>, <error>)")
+}
+
+func errorValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return v.Interface().(error)
+}
+
+var lastEventIDType = reflect.TypeOf(LastEventID(""))
+
+// checkHandlerArguments returns an error if a handler method takes arguments
+// that would never be injected for its route: path, query, header or request
+// types declared by other routes, and the *Page or LastEventID of routes that
+// are not paginated or streaming.
+func checkHandlerArguments(schema *Schema, route *RouteSchema, t reflect.Type) error {
+	declared := map[reflect.Type]bool{}
+	for _, resource := range schema.Resources {
+		for _, r := range resource.Routes {
+			for _, rt := range []reflect.Type{r.PathType, r.QueryType, r.HeaderType, r.RequestType} {
+				if rt != nil {
+					declared[indirect(rt)] = true
+				}
+			}
+		}
+	}
+	for _, rt := range []reflect.Type{route.PathType, route.QueryType, route.HeaderType, route.RequestType} {
+		if rt != nil {
+			delete(declared, indirect(rt))
+		}
+	}
+	streaming := false
+	if response := route.DefaultResponse(); response != nil {
+		streaming = response.Streaming
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		switch {
+		case declared[indirect(in)]:
+			return fmt.Errorf("takes %s, which is not a path, query, header or request type of the route", in)
+
+		case in == pageType && route.Pagination == nil:
+			return fmt.Errorf("takes %s, but the route is not paginated", in)
+
+		case in == lastEventIDType && !streaming:
+			return fmt.Errorf("takes %s, but the route is not streaming", in)
+		}
+	}
+	return nil
+}
+
+// responseValue converts a handler method's response value into the value to
+// be encoded.
+func responseValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+
+	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint()
+
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+		if v.IsNil() {
+			return nil
+		}
+	}
+	return v.Interface()
+}
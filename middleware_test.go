@@ -0,0 +1,126 @@
+package rapid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/inject"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMiddlewareUser string
+
+type testMiddlewareServer struct {
+	user testMiddlewareUser
+}
+
+func (t *testMiddlewareServer) Get(user testMiddlewareUser) (*indexResponse, error) {
+	t.user = user
+	return &indexResponse{1}, nil
+}
+
+func (t *testMiddlewareServer) Other() (*indexResponse, error) {
+	return &indexResponse{2}, nil
+}
+
+func recordingMiddleware(name string, calls *[]string) Middleware {
+	return func(route *RouteSchema, next Handler) Handler {
+		return func(i inject.Injector) (interface{}, error) {
+			*calls = append(*calls, name+":"+route.Name)
+			return next(i)
+		}
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	svc := Define("Test")
+	users := svc.Resource("Users", "/users")
+	users.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	svc.Route("Other", "/other").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testMiddlewareServer{})
+	calls := []string{}
+	assert.NoError(t, svr.UseForRoute("Get", recordingMiddleware("route", &calls)))
+	assert.NoError(t, svr.UseForResource("Users", recordingMiddleware("resource", &calls)))
+	svr.Use(recordingMiddleware("server1", &calls), recordingMiddleware("server2", &calls))
+	svr.Use(func(route *RouteSchema, next Handler) Handler {
+		return func(i inject.Injector) (interface{}, error) {
+			c := inject.New()
+			c.SetParent(i)
+			c.Map(testMiddlewareUser("bob"))
+			return next(c)
+		}
+	})
+
+	r, _ := http.NewRequest("GET", "/users/1", nil)
+	svr.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, []string{"server1:Get", "server2:Get", "resource:Get", "route:Get"}, calls)
+
+	calls = []string{}
+	r, _ = http.NewRequest("GET", "/other", nil)
+	svr.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, []string{"server1:Other", "server2:Other"}, calls)
+}
+
+func TestMiddlewareInjects(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	test := &testMiddlewareServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	svr.Use(func(route *RouteSchema, next Handler) Handler {
+		return func(i inject.Injector) (interface{}, error) {
+			c := inject.New()
+			c.SetParent(i)
+			c.Map(testMiddlewareUser("bob"))
+			return next(c)
+		}
+	})
+	r, _ := http.NewRequest("GET", "/users/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, testMiddlewareUser("bob"), test.user)
+}
+
+func TestMiddlewareShortCircuits(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/users/{id}").Get().Response(200, &indexResponse{})
+	test := &testMiddlewareServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	svr.Use(func(route *RouteSchema, next Handler) Handler {
+		return func(i inject.Injector) (interface{}, error) {
+			return nil, Error(http.StatusForbidden, "go away")
+		}
+	})
+	r, _ := http.NewRequest("GET", "/users/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, testMiddlewareUser(""), test.user)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, "{\"e\":\"go away\"}\n", w.Body.String())
+}
+
+func TestMiddlewareObservesResult(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Other", "/other").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testMiddlewareServer{})
+	svr.Use(func(route *RouteSchema, next Handler) Handler {
+		return func(i inject.Injector) (interface{}, error) {
+			response, err := next(i)
+			response.(*indexResponse).ID *= 10
+			return response, err
+		}
+	})
+	r, _ := http.NewRequest("GET", "/other", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "{\"ID\":20}\n", w.Body.String())
+}
+
+func TestMiddlewareUnknownNames(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Other", "/other").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testMiddlewareServer{})
+	assert.Error(t, svr.UseForRoute("Missing"))
+	assert.Error(t, svr.UseForResource("Missing"))
+}
@@ -5,10 +5,17 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 )
 
+// AnyMethod is the method of routes matching any HTTP method.
+const AnyMethod = "*"
+
 var (
 	pathTransform = regexp.MustCompile(`{((\w+)(?::((?:\\.|[^}])+))?)}`)
+
+	// Methods documented for routes matching any HTTP method.
+	anyMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
 )
 
 type RoutesSchema []*RouteSchema
@@ -23,6 +30,54 @@ type Schema struct {
 	Example     string            `json:"example"`
 	Version     string            `json:"version,omitempty"`
 	Resources   []*ResourceSchema `json:"resources"`
+	CORS        *CORSSchema       `json:"cors,omitempty"`
+	MediaTypes  []string          `json:"media_types,omitempty"`   // Defaults to application/json.
+	MaxBodySize int64             `json:"max_body_size,omitempty"` // Defaults to DefaultMaxBodySize.
+	RateLimit   *RateLimitSchema  `json:"rate_limit,omitempty"`    // Set by Server.RateLimit().
+
+	SecuritySchemes []*SecuritySchemeSchema `json:"security_schemes,omitempty"`
+}
+
+// DefaultMaxBodySize is the maximum size of a request body for services that
+// do not specify one. File upload routes are unlimited unless the service or
+// route sets a limit.
+var DefaultMaxBodySize int64 = 10 << 20
+
+func (s *Schema) mediaTypes() []string {
+	if len(s.MediaTypes) == 0 {
+		return []string{"application/json"}
+	}
+	return s.MediaTypes
+}
+
+// RateLimitFor returns the rate limit applied to route, or nil if it is
+// unlimited.
+func (s *Schema) RateLimitFor(route *RouteSchema) *RateLimitSchema {
+	limit := route.RateLimit
+	if limit == nil {
+		limit = s.RateLimit
+	}
+	if limit == nil || limit.Rate < 0 {
+		return nil
+	}
+	return limit
+}
+
+// MaxBodySizeFor returns the maximum size of a request body for route, or 0
+// if it is unlimited. File uploads are streamed, so DefaultMaxBodySize does
+// not apply to them.
+func (s *Schema) MaxBodySizeFor(route *RouteSchema) int64 {
+	limit := route.MaxBodySize
+	if limit == 0 {
+		limit = s.MaxBodySize
+	}
+	if limit == 0 && !route.FileUpload {
+		limit = DefaultMaxBodySize
+	}
+	if limit < 0 {
+		return 0
+	}
+	return limit
 }
 
 func (s *Schema) RouteByName(name string) *RouteSchema {
@@ -59,6 +114,7 @@ type ResourceSchema struct {
 	Name        string       `json:"name"`
 	Description string       `json:"description"`
 	Routes      RoutesSchema `json:"routes"`
+	CORS        *CORSSchema  `json:"cors,omitempty"`
 }
 
 func (r *ResourceSchema) SimplifyPath() string {
@@ -84,8 +140,15 @@ type RouteSchema struct {
 	RequestType reflect.Type      `json:"request_type"`
 	Responses   []*ResponseSchema `json:"responses"`
 	QueryType   reflect.Type      `json:"query_type"`
+	HeaderType  reflect.Type      `json:"header_type,omitempty"`
 	PathType    reflect.Type      `json:"path_type"`
 	SecuredBy   []string          `json:"secured_by"`
+	Scopes      []string          `json:"scopes,omitempty"` // Required of principals authenticated by SecuredBy.
+	WebSocket   *WebSocketSchema  `json:"websocket,omitempty"`
+	CORS        *CORSSchema       `json:"cors,omitempty"`
+	MaxBodySize int64             `json:"max_body_size,omitempty"` // Defaults to the service's limit. Negative for no limit.
+	Pagination  *PaginationSchema `json:"pagination,omitempty"`
+	RateLimit   *RateLimitSchema  `json:"rate_limit,omitempty"` // Defaults to the server's limit, if any.
 
 	Hidden bool `json:"-"` // A hint that this should be hidden from public API descriptions.
 }
@@ -94,6 +157,11 @@ func (r *RouteSchema) String() string {
 	return fmt.Sprintf("%s %s", r.Method, r.Path)
 }
 
+// IsAny returns true if the route matches any HTTP method.
+func (r *RouteSchema) IsAny() bool {
+	return r.Method == AnyMethod
+}
+
 // DefaultResponse returns the first response with a 2xx status code, assumed
 // to be the default response.
 func (r *RouteSchema) DefaultResponse() *ResponseSchema {
@@ -105,10 +173,28 @@ func (r *RouteSchema) DefaultResponse() *ResponseSchema {
 	return nil
 }
 
+// WebSocketSchema describes the messages exchanged over a WebSocket route.
+type WebSocketSchema struct {
+	InType  reflect.Type `json:"in_type"`  // Type of messages sent by the client.
+	OutType reflect.Type `json:"out_type"` // Type of messages sent by the server.
+}
+
+// CORSSchema is a Cross-Origin Resource Sharing policy. Policies on routes
+// take precedence over those on resources, which in turn take precedence over
+// the policy for the whole service.
+type CORSSchema struct {
+	AllowOrigins     []string      `json:"allow_origins"`            // "*" allows any origin.
+	AllowMethods     []string      `json:"allow_methods,omitempty"`  // Defaults to any method defined for the path.
+	AllowHeaders     []string      `json:"allow_headers,omitempty"`  // Defaults to the headers requested by the client.
+	ExposeHeaders    []string      `json:"expose_headers,omitempty"` // Response headers visible to the client.
+	AllowCredentials bool          `json:"allow_credentials,omitempty"`
+	MaxAge           time.Duration `json:"max_age,omitempty"` // How long preflight responses can be cached.
+}
+
 type ResponseSchema struct {
 	Status      int          `json:"status"`
 	Description string       `json:"description"`
-	ContentType string       `json:"content_type,omitempty"`
+	ContentType string       `json:"content_type,omitempty"` // Negotiated if empty.
 	Type        reflect.Type `json:"type"`
 	Streaming   bool         `json:"streaming,omitempty"`
 }
@@ -135,7 +221,12 @@ func (s *Schema) Types() []reflect.Type {
 				setStructType(types, r.Type)
 			}
 			setStructType(types, route.QueryType)
+			setStructType(types, route.HeaderType)
 			setStructType(types, route.PathType)
+			if route.WebSocket != nil {
+				setStructType(types, route.WebSocket.InType)
+				setStructType(types, route.WebSocket.OutType)
+			}
 		}
 	}
 
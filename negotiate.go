@@ -0,0 +1,138 @@
+package rapid
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type mediaTypeCodec struct {
+	mediaType string
+	codec     CodecFactory
+}
+
+// RegisterCodec registers a CodecFactory for a media type.
+//
+// Once any codecs are registered, request bodies are decoded with the codec
+// matching their Content-Type, and responses are encoded with the registered
+// codec best matching the Accept header. Requests with any other
+// Content-Type receive a 415 response, and requests that do not accept any
+// registered media type receive a 406 response. The default codec is used
+// when these headers are absent.
+func (s *Server) RegisterCodec(mediaType string, codec CodecFactory) *Server {
+	s.codecs = append(s.codecs, &mediaTypeCodec{mediaType, codec})
+	return s
+}
+
+func (s *Server) registeredCodec(mediaType string) CodecFactory {
+	for _, c := range s.codecs {
+		if c.mediaType == mediaType {
+			return c.codec
+		}
+	}
+	return nil
+}
+
+// requestCodec selects the codec for decoding a request body into reqi.
+func (s *Server) requestCodec(r *http.Request, reqi interface{}) (CodecFactory, error) {
+	contentType := r.Header.Get("Content-Type")
+	if len(s.codecs) == 0 || contentType == "" {
+		return s.codec, nil
+	}
+	// Types that decode themselves are not subject to negotiation.
+	if _, ok := reqi.(RequestCodec); ok {
+		return s.codec, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		if codec := s.registeredCodec(mediaType); codec != nil {
+			return codec, nil
+		}
+	}
+	return nil, ErrorForStatus(http.StatusUnsupportedMediaType)
+}
+
+// responseCodec selects the codec for encoding responses to a route.
+// Streaming and WebSocket routes are not subject to negotiation.
+func (s *Server) responseCodec(r *http.Request, route *RouteSchema) (CodecFactory, error) {
+	response := route.DefaultResponse()
+	if len(s.codecs) == 0 || route.WebSocket != nil || response != nil && response.Streaming {
+		return s.codec, nil
+	}
+	accept := parseAccept(r.Header["Accept"])
+	if response != nil && response.ContentType != "" {
+		if len(accept) > 0 && acceptQuality(accept, response.ContentType) == 0 {
+			return nil, ErrorForStatus(http.StatusNotAcceptable)
+		}
+		if codec := s.registeredCodec(response.ContentType); codec != nil {
+			return codec, nil
+		}
+		return s.codec, nil
+	}
+	if len(accept) == 0 {
+		return s.codec, nil
+	}
+	var best *mediaTypeCodec
+	bestQuality := 0.0
+	for _, c := range s.codecs {
+		if q := acceptQuality(accept, c.mediaType); q > bestQuality {
+			best, bestQuality = c, q
+		}
+	}
+	if best == nil {
+		return nil, ErrorForStatus(http.StatusNotAcceptable)
+	}
+	return best.codec, nil
+}
+
+// A mediaRange is a single entry in an Accept header.
+type mediaRange struct {
+	mediaType string
+	quality   float64
+}
+
+func parseAccept(headers []string) []*mediaRange {
+	ranges := []*mediaRange{}
+	for _, header := range headers {
+		for _, part := range strings.Split(header, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			mediaType, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			quality := 1.0
+			if q, ok := params["q"]; ok {
+				if quality, err = strconv.ParseFloat(q, 64); err != nil {
+					continue
+				}
+			}
+			ranges = append(ranges, &mediaRange{mediaType, quality})
+		}
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality of the most specific media range
+// matching mediaType, or 0 if none match.
+func acceptQuality(ranges []*mediaRange, mediaType string) float64 {
+	quality := 0.0
+	specificity := -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, r.mediaType[:len(r.mediaType)-1]):
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			quality, specificity = r.quality, s
+		}
+	}
+	return quality
+}
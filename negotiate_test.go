@@ -0,0 +1,128 @@
+package rapid
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A codec that encodes values with fmt.Sprint, and decodes request bodies as
+// a single integer.
+type testTextCodec struct {
+	v interface{}
+}
+
+func testTextCodecFactory(v interface{}) Codec {
+	return &testTextCodec{v}
+}
+
+func (t *testTextCodec) EncodeRequest() (http.Header, io.ReadCloser, error) {
+	body := fmt.Sprint(t.v)
+	return http.Header{"Content-Type": {"text/plain"}}, ioutil.NopCloser(bytes.NewBufferString(body)), nil
+}
+
+func (t *testTextCodec) DecodeRequest(r *http.Request) error {
+	_, err := fmt.Fscan(r.Body, &t.v.(*indexRequest).ID)
+	return err
+}
+
+func (t *testTextCodec) EncodeResponse(r *http.Request, w http.ResponseWriter, status int, err error) error {
+	status, err = inferStatus(r, status, err)
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(status)
+	if err != nil {
+		_, err = fmt.Fprint(w, err)
+		return err
+	}
+	_, err = fmt.Fprint(w, t.v)
+	return err
+}
+
+func (t *testTextCodec) DecodeResponse(r *http.Response) error {
+	return fmt.Errorf("not supported")
+}
+
+func (t *indexResponse) String() string {
+	return fmt.Sprintf("ID=%d", t.ID)
+}
+
+type testNegotiationServer struct{}
+
+func (t *testNegotiationServer) Index(req *indexRequest) (*indexResponse, error) {
+	return &indexResponse{req.ID * 2}, nil
+}
+
+func (t *testNegotiationServer) Get() (*indexResponse, error) {
+	return &indexResponse{1}, nil
+}
+
+func TestNegotiateResponseCodec(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/{id}").Post().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testNegotiationServer{})
+	svr.RegisterCodec("application/json", DefaultCodecFactory).RegisterCodec("text/plain", testTextCodecFactory)
+
+	w := serveTestRequest(svr, "POST", "/1", `{"ID": 2}`, nil)
+	assert.Equal(t, "{\"ID\":4}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "POST", "/1", `{"ID": 2}`, http.Header{"Accept": {"text/plain"}})
+	assert.Equal(t, "ID=4", w.Body.String())
+	assert.Equal(t, "Accept", w.Header().Get("Vary"))
+
+	w = serveTestRequest(svr, "POST", "/1", `{"ID": 2}`, http.Header{"Accept": {"application/json;q=0.5, text/*;q=0.9"}})
+	assert.Equal(t, "ID=4", w.Body.String())
+
+	w = serveTestRequest(svr, "POST", "/1", `{"ID": 2}`, http.Header{"Accept": {"text/plain;q=0, */*"}})
+	assert.Equal(t, "{\"ID\":4}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "POST", "/1", `{"ID": 2}`, http.Header{"Accept": {"image/png"}})
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestNegotiateRequestCodec(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/{id}").Post().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testNegotiationServer{})
+	svr.RegisterCodec("application/json", DefaultCodecFactory).RegisterCodec("text/plain", testTextCodecFactory)
+
+	w := serveTestRequest(svr, "POST", "/1", "3", http.Header{"Content-Type": {"text/plain; charset=utf-8"}, "Accept": {"application/json"}})
+	assert.Equal(t, "{\"ID\":6}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "POST", "/1", "<ID>3</ID>", http.Header{"Content-Type": {"application/xml"}})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestNegotiateRestrictedByContentType(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/json/{id}").Get().Responses(Response(200, &indexResponse{}).ContentType("application/json"))
+	svr := newTestServer(t, svc.Build(), &testNegotiationServer{})
+	svr.RegisterCodec("application/json", DefaultCodecFactory).RegisterCodec("text/plain", testTextCodecFactory)
+
+	w := serveTestRequest(svr, "GET", "/json/1", "", http.Header{"Accept": {"text/plain, application/json;q=0.1"}})
+	assert.Equal(t, "{\"ID\":1}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "GET", "/json/1", "", http.Header{"Accept": {"text/plain"}})
+	assert.Equal(t, http.StatusNotAcceptable, w.Code)
+}
+
+func TestAcceptQuality(t *testing.T) {
+	accept := parseAccept([]string{"text/*;q=0.5, text/plain;q=0.8", "*/*;q=0.1, invalid/"})
+	assert.Equal(t, 0.8, acceptQuality(accept, "text/plain"))
+	assert.Equal(t, 0.5, acceptQuality(accept, "text/html"))
+	assert.Equal(t, 0.1, acceptQuality(accept, "application/json"))
+	assert.Equal(t, 0.0, acceptQuality(parseAccept([]string{"text/html"}), "application/json"))
+}
+
+func TestSchemaToRAMLMediaTypes(t *testing.T) {
+	d := Define("Test").MediaTypes("application/json", "text/plain")
+	d.Route("Index", "/").Post().Request(&indexRequest{}).Response(200, &indexResponse{})
+	w := &bytes.Buffer{}
+	assert.NoError(t, SchemaToRAML("http://localhost", d.Build(), w))
+	assert.Contains(t, w.String(), "mediaType: application/json\n")
+	assert.Contains(t, w.String(), "      text/plain: {}\n")
+}
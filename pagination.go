@@ -0,0 +1,230 @@
+package rapid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// PaginationStyle is the style of the page parameters accepted by a
+// paginated route.
+type PaginationStyle string
+
+// Pagination styles.
+const (
+	// CursorPagination routes accept an opaque "cursor" query parameter,
+	// returned by the previous page.
+	CursorPagination PaginationStyle = "cursor"
+	// OffsetPagination routes accept an "offset" query parameter counting
+	// the items to skip.
+	OffsetPagination PaginationStyle = "offset"
+)
+
+// Limits on the "limit" query parameter of paginated routes.
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// PaginationSchema describes the page parameters of a paginated route.
+type PaginationSchema struct {
+	Style        PaginationStyle `json:"style"`
+	DefaultLimit int             `json:"default_limit"`
+	MaxLimit     int             `json:"max_limit"`
+}
+
+// params returns the names of the query parameters for this style of
+// pagination.
+func (p *PaginationSchema) params() []string {
+	return []string{string(p.Style), "limit"}
+}
+
+// A Page is injected into handlers of paginated routes, and holds the page
+// requested by the client. eg.
+//
+//	func (u *UsersService) ListUsers(page *rapid.Page) ([]*User, string, error) {
+//	  users := u.users[page.Offset:]
+//	  if len(users) > page.Limit {
+//	    users = users[:page.Limit]
+//	  }
+//	  return users, page.NextOffset(len(users)), nil
+//	}
+//
+// The second return value is the cursor for the next page, or "" if this is
+// the last page. It is sent to the client in a Link header.
+type Page struct {
+	Cursor string // Cursor of the page, for CursorPagination. "" for the first page.
+	Offset int    // Offset of the page, for OffsetPagination.
+	Limit  int    // Maximum number of items in the page.
+
+	style PaginationStyle
+	next  string
+}
+
+// NextOffset returns the cursor for the next page of an OffsetPagination
+// route, given the number of items n in this page. A page with fewer items
+// than the limit is assumed to be the last.
+func (p *Page) NextOffset(n int) string {
+	if n < p.Limit {
+		return ""
+	}
+	return strconv.Itoa(p.Offset + n)
+}
+
+var pageType = reflect.TypeOf(&Page{})
+
+// parsePage decodes the page parameters of a request to a paginated route.
+func parsePage(pagination *PaginationSchema, query url.Values) (*Page, error) {
+	page := &Page{Limit: pagination.DefaultLimit, style: pagination.Style}
+	fields := []*FieldError{}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		switch {
+		case err != nil:
+			fields = append(fields, &FieldError{Field: "limit", Location: LocationQuery, Code: CodeType, Message: "expected int"})
+		case n < 1:
+			fields = append(fields, &FieldError{Field: "limit", Location: LocationQuery, Code: CodeMin, Message: "must be at least 1"})
+		case n > pagination.MaxLimit:
+			fields = append(fields, &FieldError{Field: "limit", Location: LocationQuery, Code: CodeMax, Message: fmt.Sprintf("must be at most %d", pagination.MaxLimit)})
+		default:
+			page.Limit = n
+		}
+	}
+	switch pagination.Style {
+	case CursorPagination:
+		page.Cursor = query.Get("cursor")
+
+	case OffsetPagination:
+		if offset := query.Get("offset"); offset != "" {
+			n, err := strconv.Atoi(offset)
+			switch {
+			case err != nil:
+				fields = append(fields, &FieldError{Field: "offset", Location: LocationQuery, Code: CodeType, Message: "expected int"})
+			case n < 0:
+				fields = append(fields, &FieldError{Field: "offset", Location: LocationQuery, Code: CodeMin, Message: "must be at least 0"})
+			default:
+				page.Offset = n
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return nil, &ValidationError{Fields: fields}
+	}
+	return page, nil
+}
+
+// pageLinks returns an RFC 5988 Link header value with "first", "next" and,
+// for OffsetPagination, "prev" links relative to the request.
+func pageLinks(r *http.Request, page *Page) string {
+	param := string(page.style)
+	links := []string{}
+	link := func(rel string, value string) {
+		query := r.URL.Query()
+		query.Set("limit", strconv.Itoa(page.Limit))
+		if value == "" {
+			query.Del(param)
+		} else {
+			query.Set(param, value)
+		}
+		u := &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u, rel))
+	}
+	link("first", "")
+	if page.style == OffsetPagination && page.Offset > 0 {
+		prev := page.Offset - page.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		link("prev", strconv.Itoa(prev))
+	}
+	if page.next != "" {
+		link("next", page.next)
+	}
+	return strings.Join(links, ", ")
+}
+
+// parseLinks parses an RFC 5988 Link header into a map of relation types to
+// URI references.
+func parseLinks(headers []string) map[string]string {
+	links := map[string]string{}
+	for _, header := range headers {
+		for header != "" {
+			start := strings.Index(header, "<")
+			end := strings.Index(header, ">")
+			if start < 0 || end < start {
+				break
+			}
+			uri := header[start+1 : end]
+			header = header[end+1:]
+			params := header
+			if next := strings.Index(header, "<"); next >= 0 {
+				params, header = header[:next], header[next:]
+			} else {
+				header = ""
+			}
+			for _, param := range strings.Split(params, ";") {
+				param = strings.Trim(strings.TrimSpace(param), ",")
+				if !strings.HasPrefix(param, "rel=") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(param[4:], `"`)) {
+					links[rel] = uri
+				}
+			}
+		}
+	}
+	return links
+}
+
+// A PageClient is a Client that can request the pages of paginated routes,
+// such as *BasicClient.
+type PageClient interface {
+	DoPage(ctx context.Context, req *RequestTemplate, resp interface{}) (*RequestTemplate, error)
+}
+
+// A PageIterator iterates over the items of every page of a paginated route,
+// following the "next" links returned by the server. Its client must be a
+// PageClient.
+type PageIterator struct {
+	ctx    context.Context
+	client Client
+	next   *RequestTemplate
+	page   reflect.Value
+	index  int
+}
+
+// NewPageIterator creates a PageIterator starting at the page requested by
+// req.
+func NewPageIterator(ctx context.Context, client Client, req *RequestTemplate) *PageIterator {
+	return &PageIterator{ctx: ctx, client: client, next: req}
+}
+
+// Next decodes the next item into v, which must be a pointer to the
+// route's item type, fetching the next page if necessary. It returns io.EOF
+// after the last item.
+func (p *PageIterator) Next(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for !p.page.IsValid() || p.index >= p.page.Len() {
+		if p.next == nil {
+			return io.EOF
+		}
+		client, ok := p.client.(PageClient)
+		if !ok {
+			return fmt.Errorf("%T does not support pagination", p.client)
+		}
+		page := reflect.New(reflect.SliceOf(rv.Type().Elem()))
+		next, err := client.DoPage(p.ctx, p.next, page.Interface())
+		if err != nil {
+			return err
+		}
+		p.page, p.index, p.next = page.Elem(), 0, next
+	}
+	rv.Elem().Set(p.page.Index(p.index))
+	p.index++
+	return nil
+}
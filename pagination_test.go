@@ -0,0 +1,179 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type pageQuery struct {
+	Min int `schema:"min"`
+}
+
+type testPaginationServer struct {
+	items []int
+}
+
+func (t *testPaginationServer) page(query *pageQuery, offset, limit int) []int {
+	items := []int{}
+	for _, item := range t.items {
+		if item >= query.Min {
+			items = append(items, item)
+		}
+	}
+	if offset >= len(items) {
+		return []int{}
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	return items
+}
+
+func (t *testPaginationServer) Offset(query *pageQuery, page *Page) ([]int, string, error) {
+	items := t.page(query, page.Offset, page.Limit)
+	return items, page.NextOffset(len(items)), nil
+}
+
+func (t *testPaginationServer) Cursor(query *pageQuery, page *Page) ([]int, string, error) {
+	offset := 0
+	if page.Cursor != "" {
+		offset, _ = strconv.Atoi(page.Cursor)
+	}
+	items := t.page(query, offset, page.Limit)
+	next := ""
+	if len(items) == page.Limit {
+		next = strconv.Itoa(offset + len(items))
+	}
+	return items, next, nil
+}
+
+func TestOffsetPagination(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Offset", "/offset").Get().Query(&pageQuery{}).Paginated(OffsetPagination).Response(200, []int{})
+	svr := newTestServer(t, svc.Build(), &testPaginationServer{items: []int{1, 2, 3, 4, 5}})
+	w := serveTestRequest(svr, "GET", "/offset?limit=2&min=2", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "[2,3]\n", w.Body.String())
+	assert.Equal(t, `</offset?limit=2&min=2>; rel="first", </offset?limit=2&min=2&offset=2>; rel="next"`, w.Header().Get("Link"))
+
+	w = serveTestRequest(svr, "GET", "/offset?limit=2&min=2&offset=2", "", nil)
+	assert.Equal(t, "[4,5]\n", w.Body.String())
+	assert.Equal(t, `</offset?limit=2&min=2>; rel="first", </offset?limit=2&min=2&offset=0>; rel="prev", </offset?limit=2&min=2&offset=4>; rel="next"`, w.Header().Get("Link"))
+
+	w = serveTestRequest(svr, "GET", "/offset?limit=2&min=2&offset=4", "", nil)
+	assert.Equal(t, "[]\n", w.Body.String())
+	assert.NotContains(t, w.Header().Get("Link"), `rel="next"`)
+}
+
+func TestCursorPagination(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Cursor", "/cursor").Get().Query(&pageQuery{}).Paginated(CursorPagination).Response(200, []int{})
+	svr := newTestServer(t, svc.Build(), &testPaginationServer{items: []int{1, 2, 3, 4, 5}})
+	w := serveTestRequest(svr, "GET", "/cursor", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "[1,2,3,4,5]\n", w.Body.String())
+	assert.Equal(t, `</cursor?limit=20>; rel="first"`, w.Header().Get("Link"))
+
+	w = serveTestRequest(svr, "GET", "/cursor?limit=3&cursor=3", "", nil)
+	assert.Equal(t, "[4,5]\n", w.Body.String())
+	assert.Equal(t, `</cursor?limit=3>; rel="first"`, w.Header().Get("Link"))
+}
+
+func TestPaginationInvalidParameters(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Offset", "/offset").Get().Query(&pageQuery{}).Paginated(OffsetPagination).Response(200, []int{})
+	svc.Route("Cursor", "/cursor").Get().Query(&pageQuery{}).Paginated(CursorPagination).Response(200, []int{})
+	svr := newTestServer(t, svc.Build(), &testPaginationServer{items: []int{1, 2, 3, 4, 5}})
+	w := serveTestRequest(svr, "GET", "/offset?limit=1000&offset=-1", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"limit","location":"query","code":"max"`)
+	assert.Contains(t, w.Body.String(), `"field":"offset","location":"query","code":"min"`)
+
+	w = serveTestRequest(svr, "GET", "/cursor?limit=x", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"type"`)
+}
+
+type testInvalidPaginationServer struct{}
+
+func (t *testInvalidPaginationServer) Index() ([]int, int, error) {
+	return nil, 0, nil
+}
+
+func TestPaginatedHandlerMethod(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/").Get().Paginated(CursorPagination).Response(200, []int{})
+	_, err := NewServer(svc.Build(), &testInvalidPaginationServer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "should return (<response>, <next cursor>, <error>)")
+
+	assert.Panics(t, func() {
+		svc := Define("Test")
+		svc.Route("Index", "/").Get().Paginated(CursorPagination).Response(200, &indexResponse{})
+		svc.Build()
+	})
+}
+
+func TestParseLinks(t *testing.T) {
+	links := parseLinks([]string{`</a?b=1,2>; rel="first", <http://example.com/next>; title="x"; rel="next last"`})
+	assert.Equal(t, map[string]string{
+		"first": "/a?b=1,2",
+		"next":  "http://example.com/next",
+		"last":  "http://example.com/next",
+	}, links)
+}
+
+func TestPageIterator(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Offset", "/offset").Get().Query(&pageQuery{}).Paginated(OffsetPagination).Response(200, []int{})
+	svc.Route("Cursor", "/cursor").Get().Query(&pageQuery{}).Paginated(CursorPagination).Response(200, []int{})
+	svr := newTestServer(t, svc.Build(), &testPaginationServer{items: []int{1, 2, 3, 4, 5}})
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+
+	for _, path := range []string{"/offset", "/cursor"} {
+		req := Request(nil, "GET", path).Query(url.Values{"min": {"2"}, "limit": {"2"}}).Build()
+		it := NewPageIterator(context.Background(), client, req)
+		items := []int{}
+		for {
+			var item int
+			err := it.Next(&item)
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			items = append(items, item)
+		}
+		assert.Equal(t, []int{2, 3, 4, 5}, items, path)
+	}
+
+	// Clients that do not implement PageClient can not be iterated.
+	it := NewPageIterator(context.Background(), struct{ Client }{client}, Request(nil, "GET", "/offset").Build())
+	var item int
+	assert.Error(t, it.Next(&item))
+}
+
+func TestPaginationRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Offset", "/offset").Get().Query(&pageQuery{}).Paginated(OffsetPagination).Response(200, []int{})
+	svc.Route("Cursor", "/cursor").Get().Query(&pageQuery{}).Paginated(CursorPagination).Response(200, []int{})
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svc.Build(), w)
+	assert.NoError(t, err)
+	raml := w.String()
+	assert.Contains(t, raml, "      cursor:\n")
+	assert.Contains(t, raml, "      offset:\n")
+	assert.Contains(t, raml, "        maximum: 100\n")
+	assert.Contains(t, raml, "          Link:\n")
+}
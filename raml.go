@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -57,22 +59,20 @@ func SchemaToRAML(url string, s *Schema, w io.Writer) error {
 	}
 	y := rmap{
 		"baseUri":   url,
-		"mediaType": "application/json",
+		"mediaType": s.mediaTypes()[0],
 		"title":     title,
-		// "securitySchemes": []rmap{
-		// 	rmap{
-		// 		"basic": rmap{
-		// 			"type": "Basic Authentication",
-		// 		},
-		// 	},
-		// },
-		// "securedBy": []string{
-		// 	"basic",
-		// },
 		// https://github.com/raml-org/raml-js-parser/issues/108
 		// "displayName": s.Name,
 	}
 
+	if len(s.SecuritySchemes) > 0 {
+		schemes := []rmap{}
+		for _, scheme := range s.SecuritySchemes {
+			schemes = append(schemes, rmap{scheme.Name: securitySchemeToRAML(scheme)})
+		}
+		y["securitySchemes"] = schemes
+	}
+
 	typeMap := ramlTypeMap{}
 	schemas := []rmap{}
 	for _, res := range s.Resources {
@@ -81,14 +81,18 @@ func SchemaToRAML(url string, s *Schema, w io.Writer) error {
 			for _, rs := range r.Responses {
 				collectTypes(typeMap, rs.Type)
 			}
+			if r.WebSocket != nil {
+				collectTypes(typeMap, r.WebSocket.InType)
+				collectTypes(typeMap, r.WebSocket.OutType)
+			}
 		}
 	}
 	for name, t := range typeMap {
-		b, err := json.MarshalIndent(jsonschema.Reflect(reflect.New(t).Interface()), "", "  ")
+		schema, err := marshalJSONSchema(jsonschema.Reflect(reflect.New(t).Interface()), t)
 		if err != nil {
 			return err
 		}
-		schemas = append(schemas, rmap{name: string(b)})
+		schemas = append(schemas, rmap{name: schema})
 	}
 	if len(schemas) > 0 {
 		y["schemas"] = schemas
@@ -98,7 +102,7 @@ func SchemaToRAML(url string, s *Schema, w io.Writer) error {
 		if resource.Hidden() {
 			continue
 		}
-		rraml := resourceToRAML(url, resource)
+		rraml := resourceToRAML(url, s, resource)
 		rraml["displayName"] = resource.Name
 		if resource.Description != "" {
 			rraml["description"] = resource.Description
@@ -117,6 +121,34 @@ func SchemaToRAML(url string, s *Schema, w io.Writer) error {
 	return err
 }
 
+func securitySchemeToRAML(scheme *SecuritySchemeSchema) rmap {
+	out := rmap{"type": scheme.Type}
+	if scheme.Description != "" {
+		out["description"] = scheme.Description
+	}
+	describedBy := rmap{
+		"responses": rmap{
+			http.StatusUnauthorized: rmap{"description": "Missing or invalid credentials."},
+		},
+	}
+	if len(scheme.Headers) > 0 {
+		headers := rmap{}
+		for _, header := range scheme.Headers {
+			headers[header] = rmap{"type": "string"}
+		}
+		describedBy["headers"] = headers
+	}
+	if len(scheme.QueryParameters) > 0 {
+		params := rmap{}
+		for _, param := range scheme.QueryParameters {
+			params[param] = rmap{"type": "string"}
+		}
+		describedBy["queryParameters"] = params
+	}
+	out["describedBy"] = describedBy
+	return out
+}
+
 func collectTypes(typeMap ramlTypeMap, t reflect.Type) {
 	if t == nil || t == timeType {
 		return
@@ -158,11 +190,11 @@ func ramlSchemaForType(t reflect.Type) rmap {
 		schema = t.Name()
 
 	default:
-		b, err := json.MarshalIndent(jsonschema.ReflectFromType(t), "", "  ")
+		var err error
+		schema, err = marshalJSONSchema(jsonschema.ReflectFromType(t), t)
 		if err != nil {
 			panic(err)
 		}
-		schema = string(b)
 	}
 	return rmap{
 		"schema":  schema,
@@ -170,7 +202,7 @@ func ramlSchemaForType(t reflect.Type) rmap {
 	}
 }
 
-func resourceToRAML(url string, resource *ResourceSchema) rmap {
+func resourceToRAML(url string, s *Schema, resource *ResourceSchema) rmap {
 	out := rmap{}
 	// if len(r.routes) > 0 {
 	// 	route := r.routes[0]
@@ -205,70 +237,201 @@ func resourceToRAML(url string, resource *ResourceSchema) rmap {
 				out[rpath] = route
 			}
 		}
-		method := rmap{
-			"responses": rmap{},
+		if r.PathType != nil {
+			// Parameters are documented on the resource whose URI declares them.
+			params := structToRAMLParams(r.PathType, true)
+			addRAMLURIParameters(out, params, resource.SimplifyPath())
+			if rpath != "" {
+				addRAMLURIParameters(route, params, rpath)
+			}
 		}
+		if r.Method == AnyMethod {
+			// Document each method not explicitly handled by another route.
+			for _, m := range anyMethods {
+				if _, ok := route[strings.ToLower(m)]; ok {
+					continue
+				}
+				mr := *r
+				mr.Method = m
+				route[strings.ToLower(m)] = routeToRAMLMethod(url, s, &mr)
+			}
+			continue
+		}
+		route[strings.ToLower(r.Method)] = routeToRAMLMethod(url, s, r)
+	}
+	return out
+}
 
-		// Responses
-		responseMap := rmap{}
-		method["responses"] = responseMap
-		if r.QueryType != nil {
-			method["queryParameters"] = structToRAMLParams(r.QueryType, false)
+func addRAMLURIParameters(resource rmap, params rmap, path string) {
+	for _, match := range varRegex.FindAllStringSubmatch(path, -1) {
+		param, ok := params[match[1]]
+		if !ok {
+			continue
 		}
-		for _, response := range r.Responses {
-			ct := response.ContentType
-			if ct == "" {
-				ct = "application/json"
-			}
-			rresp := ramlSchemaForType(response.Type)
-			rrm := rmap{
-				"body": rmap{
-					ct: rresp,
+		uriParameters, ok := resource["uriParameters"].(rmap)
+		if !ok {
+			uriParameters = rmap{}
+			resource["uriParameters"] = uriParameters
+		}
+		uriParameters[match[1]] = param
+	}
+}
+
+func routeToRAMLMethod(url string, s *Schema, r *RouteSchema) rmap {
+	method := rmap{
+		"responses": rmap{},
+	}
+
+	// Responses
+	responseMap := rmap{}
+	method["responses"] = responseMap
+	if r.QueryType != nil {
+		method["queryParameters"] = structToRAMLParams(r.QueryType, false)
+	}
+	if r.HeaderType != nil {
+		method["headers"] = structToRAMLParams(r.HeaderType, false)
+	}
+	if r.Pagination != nil {
+		params, _ := method["queryParameters"].(rmap)
+		if params == nil {
+			params = rmap{}
+			method["queryParameters"] = params
+		}
+		addRAMLPageParameters(params, r.Pagination)
+	}
+	for _, response := range r.Responses {
+		rrm := rmap{
+			"body": ramlBodies(s.mediaTypes(), response.ContentType, response.Type, ""),
+		}
+		description := response.Description
+		if response.Streaming {
+			description = "Streaming response."
+			rrm["headers"] = rmap{
+				"Content-Encoding": rmap{
+					"type": "string",
 				},
 			}
-			description := response.Description
-			if response.Streaming {
-				description = "Streaming response."
-				rrm["headers"] = rmap{
-					"Content-Encoding": rmap{
-						"type": "string",
-					},
-				}
+			rrm["body"].(rmap)[EventStreamMediaType] = rmap{
+				"example": makeRAMLEventStreamExample(response.Type),
 			}
-			if description != "" {
-				rrm["description"] = description
+		}
+		if r.Pagination != nil && response == r.DefaultResponse() {
+			rrm["headers"] = rmap{
+				"Link": rmap{
+					"type":        "string",
+					"description": "RFC 5988 links to the first, previous and next pages.",
+				},
 			}
-			responseMap[response.Status] = rrm
 		}
+		if description != "" {
+			rrm["description"] = description
+		}
+		responseMap[response.Status] = rrm
+	}
 
-		// FIXME: This should work:
-		// https://github.com/raml-org/raml-js-parser/issues/108
-		// method["displayName"] = r.Name
-		description := r.Name
-		if r.Description != "" {
-			description += " - " + r.Description
-		}
-		if !strings.Contains(description, "curl") {
-			example := makeRAMLRequestExample(url, r)
-			// FIXME: raml2html has a weird thing where it strips a leading
-			// space off subsequent indented lines. I compensate here by
-			// adding 5 characters...
-			example = "    " + strings.Join(strings.Split(example, "\n"), "\n     ")
-			description += "\n\n\n" + example
-		}
-		method["description"] = description
-		if r.RequestType != nil {
-			rreq := ramlSchemaForType(r.RequestType)
-			if r.Example != "" {
-				rreq["example"] = r.Example
+	// FIXME: This should work:
+	// https://github.com/raml-org/raml-js-parser/issues/108
+	// method["displayName"] = r.Name
+	description := r.Name
+	if r.Description != "" {
+		description += " - " + r.Description
+	}
+	if !strings.Contains(description, "curl") {
+		var example string
+		if r.WebSocket != nil {
+			example = makeRAMLWebSocketExample(url, r)
+		} else {
+			example = makeRAMLRequestExample(url, r)
+		}
+		// FIXME: raml2html has a weird thing where it strips a leading
+		// space off subsequent indented lines. I compensate here by
+		// adding 5 characters...
+		example = "    " + strings.Join(strings.Split(example, "\n"), "\n     ")
+		description += "\n\n\n" + example
+	}
+	method["description"] = description
+	if len(r.SecuredBy) > 0 {
+		if len(r.Scopes) > 0 {
+			securedBy := []interface{}{}
+			for _, name := range r.SecuredBy {
+				securedBy = append(securedBy, rmap{name: rmap{"scopes": r.Scopes}})
 			}
-			method["body"] = rmap{
-				"application/json": rreq,
+			method["securedBy"] = securedBy
+			responseMap[http.StatusForbidden] = rmap{
+				"description": fmt.Sprintf("Caller lacks the required scopes: %s.", strings.Join(r.Scopes, ", ")),
 			}
+		} else {
+			method["securedBy"] = r.SecuredBy
 		}
-		route[strings.ToLower(r.Method)] = method
 	}
-	return out
+	if limit := s.RateLimitFor(r); limit != nil {
+		integer := rmap{"type": "integer"}
+		responseMap[http.StatusTooManyRequests] = rmap{
+			"description": fmt.Sprintf("Rate limit of %v requests per second, with bursts of %d, exceeded.", limit.Rate, limit.Burst),
+			"headers": rmap{
+				"Retry-After":         integer,
+				"RateLimit-Limit":     integer,
+				"RateLimit-Remaining": integer,
+				"RateLimit-Reset":     integer,
+			},
+		}
+	}
+	if r.RequestType != nil {
+		method["body"] = ramlBodies(s.mediaTypes(), "", r.RequestType, r.Example)
+		if limit := s.MaxBodySizeFor(r); limit > 0 {
+			responseMap[http.StatusRequestEntityTooLarge] = rmap{
+				"description": fmt.Sprintf("Request body exceeds %d bytes.", limit),
+			}
+		}
+	}
+	return method
+}
+
+// addRAMLPageParameters documents the page parameters of a paginated route.
+func addRAMLPageParameters(params rmap, pagination *PaginationSchema) {
+	switch pagination.Style {
+	case CursorPagination:
+		params["cursor"] = rmap{
+			"type":        "string",
+			"description": "Cursor of the page to return, from the Link header of the previous page.",
+		}
+
+	case OffsetPagination:
+		params["offset"] = rmap{
+			"type":        "integer",
+			"description": "Number of items to skip.",
+			"minimum":     0,
+			"default":     0,
+		}
+	}
+	params["limit"] = rmap{
+		"type":        "integer",
+		"description": "Maximum number of items to return.",
+		"minimum":     1,
+		"maximum":     pagination.MaxLimit,
+		"default":     pagination.DefaultLimit,
+	}
+}
+
+// ramlBodies documents a body of type t for each supported media type, or
+// only contentType if it is set. Schemas and examples are JSON, so are only
+// included for JSON media types.
+func ramlBodies(mediaTypes []string, contentType string, t reflect.Type, example string) rmap {
+	if contentType != "" {
+		mediaTypes = []string{contentType}
+	}
+	bodies := rmap{}
+	for _, mediaType := range mediaTypes {
+		body := rmap{}
+		if mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") || mediaType == "application/x-ndjson" {
+			body = ramlSchemaForType(t)
+			if example != "" {
+				body["example"] = example
+			}
+		}
+		bodies[mediaType] = body
+	}
+	return bodies
 }
 
 func makeRAMLRequestExample(url string, route *RouteSchema) string {
@@ -283,12 +446,30 @@ func makeRAMLRequestExample(url string, route *RouteSchema) string {
 	w.WriteString(" " + url + route.Path)
 	w.WriteString("\n")
 	res := route.DefaultResponse()
-	if res.Type != nil {
+	if res != nil && res.Type != nil {
 		w.WriteString(makeRAMLExample(res.Type, true))
 	}
 	return w.String()
 }
 
+func makeRAMLWebSocketExample(url string, route *RouteSchema) string {
+	w := &bytes.Buffer{}
+	w.WriteString("WebSocket " + strings.Replace(url, "http", "ws", 1) + route.Path + "\n")
+	w.WriteString("\nClient messages:\n")
+	w.WriteString("{\"d\": " + makeRAMLExample(route.WebSocket.InType, false) + "}\n")
+	w.WriteString("\nServer messages:\n")
+	w.WriteString("{\"d\": " + makeRAMLExample(route.WebSocket.OutType, false) + "}\n")
+	return w.String()
+}
+
+func makeRAMLEventStreamExample(t reflect.Type) string {
+	data := "null"
+	if t != nil {
+		data = makeRAMLExample(t, false)
+	}
+	return "id: 1\nevent: message\ndata: " + data + "\n\n"
+}
+
 type cycleMap map[reflect.Type]bool
 
 func makeRAMLExample(t reflect.Type, indent bool) string {
@@ -391,14 +572,155 @@ func structToRAMLParams(t reflect.Type, required bool) rmap {
 		f := t.Field(i)
 		name, _ := parseTag(f)
 		rm := typeToRAML(f.Type)
-		if required {
+		if required || hasTagOption(strings.Split(f.Tag.Get("header"), ",")[1:], "required") {
 			rm["required"] = true
 		}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			c, err := parseConstraints(tag)
+			if err != nil {
+				panic(err)
+			}
+			constraintsToRAML(rm, f.Type, c)
+		}
 		out[name] = rm
 	}
 	return out
 }
 
+// constraintsToRAML adds named parameter properties for constraints declared
+// in a validate tag.
+func constraintsToRAML(rm rmap, t reflect.Type, c *constraints) {
+	if c.required {
+		rm["required"] = true
+	}
+	if isNumberKind(indirect(t).Kind()) {
+		if c.min != nil {
+			rm["minimum"] = *c.min
+		}
+		if c.max != nil {
+			rm["maximum"] = *c.max
+		}
+	} else {
+		if c.min != nil {
+			rm["minLength"] = int(*c.min)
+		}
+		if c.max != nil {
+			rm["maxLength"] = int(*c.max)
+		}
+	}
+	if c.pattern != nil {
+		rm["pattern"] = c.pattern.String()
+	}
+	if c.oneOf != nil {
+		rm["enum"] = c.oneOf
+	}
+}
+
+// marshalJSONSchema encodes the JSON schema reflected from t, adding the
+// constraints declared in validate tags on the structs t refers to.
+func marshalJSONSchema(schema *jsonschema.Schema, t reflect.Type) (string, error) {
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return "", err
+	}
+	if err := addJSONSchemaConstraints(doc, cycleMap{}, t); err != nil {
+		return "", err
+	}
+	b, err = json.MarshalIndent(doc, "", "  ")
+	return string(b), err
+}
+
+// addJSONSchemaConstraints adds constraints to the definitions of t, and of
+// the structs nested in t, in the JSON schema doc.
+func addJSONSchemaConstraints(doc map[string]interface{}, cycles cycleMap, t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return addJSONSchemaConstraints(doc, cycles, t.Elem())
+
+	case reflect.Struct:
+		if cycles[t] || t == timeType || t.Name() == "" {
+			return nil
+		}
+		cycles[t] = true
+		fields, err := structConstraints(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			ft := t.Field(f.index).Type
+			if f.constraints != nil {
+				definition := jsonSchemaObject(jsonSchemaObject(doc, "definitions"), t.Name())
+				constraintsToJSONSchema(definition, f.name, ft, f.constraints)
+			}
+			if err := addJSONSchemaConstraints(doc, cycles, ft); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// constraintsToJSONSchema adds JSON schema validation keywords for the
+// constraints declared on the property name of definition.
+func constraintsToJSONSchema(definition map[string]interface{}, name string, t reflect.Type, c *constraints) {
+	if c.required {
+		required, _ := definition["required"].([]interface{})
+		listed := false
+		for _, r := range required {
+			listed = listed || r == name
+		}
+		if !listed {
+			definition["required"] = append(required, name)
+		}
+	}
+	property := jsonSchemaObject(jsonSchemaObject(definition, "properties"), name)
+	kind := indirect(t).Kind()
+	min, max := "minLength", "maxLength"
+	switch {
+	case isNumberKind(kind):
+		min, max = "minimum", "maximum"
+	case kind == reflect.Slice || kind == reflect.Array:
+		min, max = "minItems", "maxItems"
+	case kind == reflect.Map:
+		min, max = "minProperties", "maxProperties"
+	}
+	if c.min != nil {
+		property[min] = *c.min
+	}
+	if c.max != nil {
+		property[max] = *c.max
+	}
+	if c.pattern != nil {
+		property["pattern"] = c.pattern.String()
+	}
+	if c.oneOf != nil {
+		enum := []interface{}{}
+		for _, value := range c.oneOf {
+			if n, err := strconv.ParseFloat(value, 64); err == nil && isNumberKind(kind) {
+				enum = append(enum, n)
+			} else {
+				enum = append(enum, value)
+			}
+		}
+		property["enum"] = enum
+	}
+}
+
+// jsonSchemaObject returns the object under key in parent, creating it if
+// necessary.
+func jsonSchemaObject(parent map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := parent[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := map[string]interface{}{}
+	parent[key] = child
+	return child
+}
+
 func typeToRAML(t reflect.Type) rmap {
 	switch t.Kind() {
 	case reflect.Struct:
@@ -422,6 +744,11 @@ func typeToRAML(t reflect.Type) rmap {
 
 	case reflect.Ptr:
 		return typeToRAML(t.Elem())
+
+	case reflect.Slice:
+		rm := typeToRAML(t.Elem())
+		rm["repeat"] = true
+		return rm
 	}
 	panic("unsupported type " + t.String())
 }
@@ -452,5 +779,8 @@ func parseTag(f reflect.StructField) (name string, required bool) {
 			name = schema
 		}
 	}
+	if header := strings.Split(f.Tag.Get("header"), ",")[0]; header != "" {
+		name = header
+	}
 	return
 }
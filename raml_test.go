@@ -67,3 +67,19 @@ func TestMakeExample(t *testing.T) {
   ]
 }`, raml)
 }
+
+func TestSchemaToRAMLAnyMethod(t *testing.T) {
+	d := Define("Test")
+	users := d.Resource("Users", "/user")
+	users.Route("Any", "/user").Any()
+	users.Route("List", "/user").Get().Response(200, []TestRAMLResponseType{})
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", d.Build(), w)
+	assert.NoError(t, err)
+	raml := w.String()
+	for _, method := range []string{"get", "post", "put", "patch", "delete"} {
+		assert.Regexp(t, `\n\s+`+method+`:\n`, raml)
+	}
+	assert.Contains(t, raml, "curl -X PATCH")
+	assert.NotContains(t, raml, "Any\n\n\n    $ curl http")
+}
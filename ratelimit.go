@@ -0,0 +1,221 @@
+package rapid
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitSchema is a token bucket rate limit applied to each caller of a
+// route.
+type RateLimitSchema struct {
+	Rate  float64 `json:"rate"`  // Requests per second. Negative for no limit.
+	Burst int     `json:"burst"` // Maximum number of requests at once.
+}
+
+// A RateLimitKeyFunc identifies the caller of a request for rate limiting.
+// Requests for which it returns "" are limited by client IP.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitByIP identifies callers by the IP address of the client.
+func RateLimitByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByHeader identifies callers by the value of a request header,
+// such as an API key.
+//
+// Callers choose the values of their headers, and can evade limits by
+// varying them. Only use it when the header is set by a trusted proxy, or is
+// otherwise verified before requests reach the server.
+func RateLimitByHeader(name string) RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// RateLimitByPrincipal identifies callers of secured routes by their
+// authenticated principal, formatted with fmt.Sprint.
+func RateLimitByPrincipal(r *http.Request) string {
+	if principal := PrincipalFromContext(r.Context()); principal != nil {
+		return fmt.Sprint(principal)
+	}
+	return ""
+}
+
+// RateLimit sets the default rate limit for routes that do not specify one,
+// in requests per second per caller. Callers may make up to burst requests at
+// once. Callers exceeding the limit receive a 429 response with Retry-After
+// and RateLimit-* headers.
+//
+// The limit is added to the server's schema, and is documented in RAML
+// generated from it.
+func (s *Server) RateLimit(rate float64, burst int) *Server {
+	s.schema.RateLimit = &RateLimitSchema{Rate: rate, Burst: burst}
+	for _, match := range s.matches {
+		if match.route.RateLimit == nil {
+			match.limiter = newRateLimiter(&RateLimitSchema{Rate: rate, Burst: burst})
+		}
+	}
+	return s
+}
+
+// RateLimitKey sets the function used to identify callers for rate limiting.
+// The default is RateLimitByIP.
+//
+// Callers are limited before authentication if key identifies them from the
+// request alone, so that unauthenticated requests count against the limit.
+// Otherwise, as with RateLimitByPrincipal, they are limited once
+// authenticated, and requests that fail authentication are limited by IP.
+func (s *Server) RateLimitKey(key RateLimitKeyFunc) *Server {
+	s.rateLimitKey = key
+	return s
+}
+
+var errRateLimitExceeded = Error(http.StatusTooManyRequests, "rate limit exceeded")
+
+// rateLimitKeyOf identifies the caller of a request for rate limiting, or
+// returns "" if the caller can not be identified yet, eg. by a principal
+// before authentication.
+func (s *Server) rateLimitKeyOf(r *http.Request) string {
+	if s.rateLimitKey == nil {
+		return RateLimitByIP(r)
+	}
+	return s.rateLimitKey(r)
+}
+
+// applyRateLimit takes a token for the caller identified by key from the
+// route's rate limiter, and sets the RateLimit-* headers. It returns false if
+// the caller has exceeded the limit, in which case Retry-After is also set.
+func (s *Server) applyRateLimit(w http.ResponseWriter, limiter *rateLimiter, key string) bool {
+	ok, remaining, reset, retryAfter := limiter.take(key)
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(limiter.limit.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	if !ok {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	}
+	return ok
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter is an in-memory token bucket per caller.
+type rateLimiter struct {
+	limit *RateLimitSchema
+	now   func() time.Time
+
+	lock    sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// newRateLimiter returns a rateLimiter, or nil if limit is disabled.
+func newRateLimiter(limit *RateLimitSchema) *rateLimiter {
+	if limit == nil || limit.Rate < 0 {
+		return nil
+	}
+	if limit.Burst < 1 {
+		limit = &RateLimitSchema{Rate: limit.Rate, Burst: 1}
+	}
+	return &rateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// take a token from the bucket for key. It returns whether a token was
+// available, the number of tokens remaining, the time until the bucket is
+// full, and the time until a token will be available.
+func (l *rateLimiter) take(key string) (ok bool, remaining int, reset, retryAfter time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	now := l.now()
+	burst := float64(l.limit.Burst)
+	l.sweep(now)
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.limit.Rate)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		ok = true
+		bucket.tokens--
+	} else {
+		retryAfter = l.duration(1 - bucket.tokens)
+	}
+	return ok, int(bucket.tokens), l.duration(burst - bucket.tokens), retryAfter
+}
+
+// duration returns the time taken to accumulate n tokens.
+func (l *rateLimiter) duration(n float64) time.Duration {
+	if l.limit.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(n / l.limit.Rate * float64(time.Second))
+}
+
+// sweep removes the buckets of callers that have refilled since their last
+// request, at most once per refill period.
+func (l *rateLimiter) sweep(now time.Time) {
+	if l.limit.Rate <= 0 {
+		return
+	}
+	full := l.duration(float64(l.limit.Burst))
+	if now.Sub(l.swept) < full {
+		return
+	}
+	l.swept = now
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// A RateLimitError is returned by clients when a route's rate limit has been
+// exceeded.
+type RateLimitError struct {
+	HTTPStatus
+	Limit      int           // From RateLimit-Limit.
+	Remaining  int           // From RateLimit-Remaining.
+	Reset      time.Duration // From RateLimit-Reset.
+	RetryAfter time.Duration // From Retry-After.
+}
+
+func newRateLimitError(response *http.Response, err error) *RateLimitError {
+	header := func(key string) int {
+		n, _ := strconv.Atoi(response.Header.Get(key))
+		return n
+	}
+	status := HTTPStatus{Status: response.StatusCode, Message: http.StatusText(response.StatusCode), Headers: response.Header}
+	if err != nil {
+		status.Message = err.Error()
+	}
+	return &RateLimitError{
+		HTTPStatus: status,
+		Limit:      header("RateLimit-Limit"),
+		Remaining:  header("RateLimit-Remaining"),
+		Reset:      time.Duration(header("RateLimit-Reset")) * time.Second,
+		RetryAfter: time.Duration(header("Retry-After")) * time.Second,
+	}
+}
@@ -0,0 +1,217 @@
+package rapid
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testRateLimitServer struct{}
+
+func (t *testRateLimitServer) Limited() (*indexResponse, error) {
+	return &indexResponse{1}, nil
+}
+
+func (t *testRateLimitServer) Unlimited() (*indexResponse, error) {
+	return &indexResponse{2}, nil
+}
+
+func (t *testRateLimitServer) Default() (*indexResponse, error) {
+	return &indexResponse{3}, nil
+}
+
+func (t *testRateLimitServer) Secured() (*indexResponse, error) {
+	return &indexResponse{4}, nil
+}
+
+// stubRateLimitClock makes the rate limiters of svr use the time in now.
+func stubRateLimitClock(svr *Server, now *time.Time) {
+	for _, match := range svr.matches {
+		if match.limiter != nil {
+			match.limiter.now = func() time.Time { return *now }
+		}
+	}
+}
+
+// otherCaller serves requests to svr from a different client address.
+func otherCaller(svr *Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "5.6.7.8:1000"
+		svr.ServeHTTP(w, r)
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	now := time.Unix(1000, 0)
+	svc := Define("Test")
+	svc.Route("Limited", "/limited").Get().RateLimit(1, 2).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1)
+	stubRateLimitClock(svr, &now)
+
+	w := serveTestRequest(svr, "GET", "/limited", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", w.Header().Get("RateLimit-Reset"))
+
+	w = serveTestRequest(svr, "GET", "/limited", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+
+	w = serveTestRequest(svr, "GET", "/limited", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, "2", w.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "{\"e\":\"rate limit exceeded\"}\n", w.Body.String())
+
+	// Other callers have their own buckets.
+	w = serveTestRequest(otherCaller(svr), "GET", "/limited", "", nil)
+	assert.Equal(t, 200, w.Code)
+
+	// Tokens are replenished over time.
+	now = now.Add(time.Second)
+	w = serveTestRequest(svr, "GET", "/limited", "", nil)
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(svr, "GET", "/limited", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestRateLimitDefault(t *testing.T) {
+	now := time.Unix(1000, 0)
+	svc := Define("Test")
+	svc.Route("Default", "/default").Get().Response(200, &indexResponse{})
+	svc.Route("Unlimited", "/unlimited").Get().RateLimit(-1, 0).Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1)
+	stubRateLimitClock(svr, &now)
+
+	w := serveTestRequest(svr, "GET", "/default", "", nil)
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(svr, "GET", "/default", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	for i := 0; i < 5; i++ {
+		w = serveTestRequest(svr, "GET", "/unlimited", "", nil)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "", w.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	now := time.Unix(1000, 0)
+	svc := Define("Test")
+	svc.Route("Default", "/default").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1).RateLimitKey(RateLimitByHeader("X-API-Key"))
+	stubRateLimitClock(svr, &now)
+
+	w := serveTestRequest(svr, "GET", "/default", "", http.Header{"X-Api-Key": {"a"}})
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(svr, "GET", "/default", "", http.Header{"X-Api-Key": {"b"}})
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(otherCaller(svr), "GET", "/default", "", http.Header{"X-Api-Key": {"a"}})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Requests without a key are limited by IP.
+	w = serveTestRequest(svr, "GET", "/default", "", nil)
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(svr, "GET", "/default", "", nil)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestRateLimitAuthentication(t *testing.T) {
+	now := time.Unix(1000, 0)
+	auth := BasicAuth("test", func(username, password string) (Principal, error) {
+		if username == "mallory" {
+			return nil, ErrorForStatus(http.StatusUnauthorized)
+		}
+		return username, nil
+	})
+
+	login := func(username string) http.Header {
+		return http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte(username+":password"))}}
+	}
+
+	// Callers identified by IP are limited before authentication.
+	svc := Define("Test")
+	svc.Route("Secured", "/secured").Get().SecuredBy("basic").Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1).SecurityScheme("basic", auth)
+	stubRateLimitClock(svr, &now)
+	assert.Equal(t, http.StatusUnauthorized, serveTestRequest(svr, "GET", "/secured", "", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveTestRequest(svr, "GET", "/secured", "", nil).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveTestRequest(svr, "GET", "/secured", "", login("alice")).Code)
+
+	// Callers identified by principal are limited after authentication, and
+	// by IP if authentication fails.
+	svr = newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1).SecurityScheme("basic", auth).RateLimitKey(RateLimitByPrincipal)
+	stubRateLimitClock(svr, &now)
+	assert.Equal(t, http.StatusUnauthorized, serveTestRequest(svr, "GET", "/secured", "", login("mallory")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveTestRequest(svr, "GET", "/secured", "", login("mallory")).Code)
+	assert.Equal(t, 200, serveTestRequest(svr, "GET", "/secured", "", login("alice")).Code)
+	assert.Equal(t, http.StatusTooManyRequests, serveTestRequest(svr, "GET", "/secured", "", login("alice")).Code)
+	assert.Equal(t, 200, serveTestRequest(svr, "GET", "/secured", "", login("bob")).Code)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter := newRateLimiter(&RateLimitSchema{Rate: 1, Burst: 2})
+	limiter.now = func() time.Time { return now }
+	limiter.take("a")
+	now = now.Add(time.Second)
+	limiter.take("b")
+	now = now.Add(time.Second)
+	limiter.take("c")
+	assert.Equal(t, 2, len(limiter.buckets))
+	assert.NotContains(t, limiter.buckets, "a")
+}
+
+func TestClientRateLimitError(t *testing.T) {
+	now := time.Unix(1000, 0)
+	svc := Define("Test")
+	svc.Route("Default", "/default").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1)
+	stubRateLimitClock(svr, &now)
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+	client, err := Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+
+	resp := &indexResponse{}
+	assert.NoError(t, client.Do(Request(nil, "GET", "/default").Build(), resp))
+	err = client.Do(Request(nil, "GET", "/default").Build(), resp)
+	rerr, ok := err.(*RateLimitError)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusTooManyRequests, rerr.Status)
+	assert.Equal(t, "rate limit exceeded", rerr.Error())
+	assert.Equal(t, 1, rerr.Limit)
+	assert.Equal(t, 0, rerr.Remaining)
+	assert.Equal(t, time.Second, rerr.RetryAfter)
+}
+
+func TestRateLimitRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Limited", "/limited").Get().RateLimit(1, 2).Response(200, &indexResponse{})
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svc.Build(), w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "Rate limit of 1 requests per second, with bursts of 2, exceeded.")
+	assert.Contains(t, w.String(), "Retry-After:")
+}
+
+func TestRateLimitDefaultRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Limited", "/limited").Get().RateLimit(1, 2).Response(200, &indexResponse{})
+	svc.Route("Unlimited", "/unlimited").Get().RateLimit(-1, 0).Response(200, &indexResponse{})
+	svc.Route("Default", "/default").Get().Response(200, &indexResponse{})
+	svc.Route("Secured", "/secured").Get().SecuredBy("basic").Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testRateLimitServer{}).RateLimit(10, 1)
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svr.schema, w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "Rate limit of 10 requests per second, with bursts of 1, exceeded.")
+	assert.Equal(t, 3, strings.Count(w.String(), "Rate limit of"))
+}
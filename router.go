@@ -0,0 +1,264 @@
+package rapid
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// A router matches request paths against route patterns using a prefix tree
+// of path segments.
+//
+// Literal segments are matched first, followed by segments consisting of a
+// single parameter. Constrained parameters ({name:regex}) are checked against
+// only the segment they occupy. Any remainder of a pattern that can not be
+// matched segment by segment (eg. parameters with a regex that may match "/",
+// or segments mixing literals and parameters) is matched with the route's
+// fully compiled pattern, preserving the semantics of CompilePath().
+type router struct {
+	root *routerNode
+}
+
+type routerNode struct {
+	literals map[string]*routerNode
+	params   []*routerParam
+	routes   []*routeMatch // Routes terminating at this node.
+	tails    []*routeMatch // Routes matched with their full pattern from this node.
+}
+
+type routerParam struct {
+	key     string         // Parameter definition, used to share nodes between routes.
+	pattern *regexp.Regexp // Nil if the parameter is unconstrained.
+	node    *routerNode
+}
+
+func newRouterNode() *routerNode {
+	return &routerNode{literals: map[string]*routerNode{}}
+}
+
+func newRouter(matches []*routeMatch) *router {
+	r := &router{root: newRouterNode()}
+	for _, match := range matches {
+		r.add(match)
+	}
+	return r
+}
+
+func (r *router) add(match *routeMatch) {
+	node := r.root
+	path := match.route.Path
+	if !strings.HasPrefix(path, "/") {
+		node.tails = append(node.tails, match)
+		return
+	}
+	for _, segment := range splitPattern(path[1:]) {
+		if isLiteralSegment(segment) {
+			next, ok := node.literals[segment]
+			if !ok {
+				next = newRouterNode()
+				node.literals[segment] = next
+			}
+			node = next
+			continue
+		}
+		param, ok := parseParamSegment(segment)
+		if !ok {
+			node.tails = append(node.tails, match)
+			return
+		}
+		var found *routerParam
+		for _, p := range node.params {
+			if p.key == param.key {
+				found = p
+				break
+			}
+		}
+		if found == nil {
+			found = param
+			found.node = newRouterNode()
+			node.params = append(node.params, found)
+		}
+		node = found.node
+	}
+	node.routes = append(node.routes, match)
+}
+
+// visit calls fn for each route matching path, in priority order, until fn
+// returns true.
+func (r *router) visit(path string, fn func(match *routeMatch, params Params) bool) bool {
+	if !strings.HasPrefix(path, "/") {
+		return r.visitTails(r.root, path, fn)
+	}
+	return r.visitNode(r.root, path, strings.Split(path[1:], "/"), nil, fn)
+}
+
+func (r *router) visitNode(node *routerNode, path string, segments []string, values []string, fn func(*routeMatch, Params) bool) bool {
+	if len(segments) == 0 {
+		for _, match := range node.routes {
+			params := Params{}
+			for i, k := range match.params {
+				params[k] = values[i]
+			}
+			if fn(match, params) {
+				return true
+			}
+		}
+		return r.visitTails(node, path, fn)
+	}
+	segment := segments[0]
+	if next, ok := node.literals[segment]; ok {
+		if r.visitNode(next, path, segments[1:], values, fn) {
+			return true
+		}
+	}
+	for _, param := range node.params {
+		if param.pattern == nil && segment == "" || param.pattern != nil && !param.pattern.MatchString(segment) {
+			continue
+		}
+		if r.visitNode(param.node, path, segments[1:], append(values[:len(values):len(values)], segment), fn) {
+			return true
+		}
+	}
+	return r.visitTails(node, path, fn)
+}
+
+func (r *router) visitTails(node *routerNode, path string, fn func(*routeMatch, Params) bool) bool {
+	for _, match := range node.tails {
+		matches := match.pattern.FindStringSubmatch(path)
+		if matches == nil {
+			continue
+		}
+		params := Params{}
+		for i, k := range match.params {
+			params[k] = matches[i+1]
+		}
+		if fn(match, params) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns the first route matching method and path.
+func (r *router) match(method, path string) (*routeMatch, Params) {
+	var found *routeMatch
+	var foundParams Params
+	r.visit(path, func(match *routeMatch, params Params) bool {
+		if match.route.Method == method {
+			found, foundParams = match, params
+			return true
+		}
+		return false
+	})
+	return found, foundParams
+}
+
+// methods returns the sorted set of HTTP methods that can be used with path.
+// HEAD is allowed for any path supporting GET, and OPTIONS for any path
+// matching a route.
+func (r *router) methods(path string) []string {
+	set := map[string]bool{}
+	r.visit(path, func(match *routeMatch, params Params) bool {
+		if !match.route.IsAny() {
+			set[match.route.Method] = true
+		}
+		return false
+	})
+	if len(set) == 0 {
+		return nil
+	}
+	if set["GET"] {
+		set["HEAD"] = true
+	}
+	set["OPTIONS"] = true
+	methods := []string{}
+	for method := range set {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// splitPattern splits a path pattern on "/", ignoring separators inside
+// parameter definitions.
+func splitPattern(path string) []string {
+	spans := pathTransform.FindAllStringIndex(path, -1)
+	segments := []string{}
+	start := 0
+	for i := 0; i < len(path); i++ {
+		if len(spans) > 0 && i == spans[0][0] {
+			i = spans[0][1] - 1
+			spans = spans[1:]
+			continue
+		}
+		if path[i] == '/' {
+			segments = append(segments, path[start:i])
+			start = i + 1
+		}
+	}
+	return append(segments, path[start:])
+}
+
+func isLiteralSegment(segment string) bool {
+	return !pathTransform.MatchString(segment) && regexp.QuoteMeta(segment) == segment
+}
+
+// parseParamSegment parses a segment consisting of exactly one parameter
+// whose pattern can not match "/".
+func parseParamSegment(segment string) (*routerParam, bool) {
+	match := pathTransform.FindStringSubmatch(segment)
+	if match == nil || match[0] != segment {
+		return nil, false
+	}
+	if match[3] == "" {
+		return &routerParam{key: "{}"}, true
+	}
+	pattern := strings.Replace(match[3], `\{`, "{", -1)
+	pattern = strings.Replace(pattern, `\}`, "}", -1)
+	if canMatchSlash(pattern) {
+		return nil, false
+	}
+	rx, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, false
+	}
+	return &routerParam{key: "{:" + pattern + "}", pattern: rx}, true
+}
+
+// canMatchSlash returns true if the regular expression pattern could match
+// a "/" character, or can not be parsed.
+func canMatchSlash(pattern string) bool {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return true
+	}
+	return regexpCanMatchSlash(re)
+}
+
+func regexpCanMatchSlash(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '/' {
+				return true
+			}
+		}
+
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '/' && '/' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if regexpCanMatchSlash(sub) {
+			return true
+		}
+	}
+	return false
+}
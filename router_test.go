@@ -0,0 +1,135 @@
+package rapid
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestRouteMatches(paths ...string) []*routeMatch {
+	matches := []*routeMatch{}
+	for i, path := range paths {
+		route := &RouteSchema{Name: fmt.Sprintf("Route%d", i), Path: path, Method: "GET"}
+		pattern, params := route.CompilePath()
+		matches = append(matches, &routeMatch{route: route, pattern: pattern, params: params})
+	}
+	return matches
+}
+
+// linearMatch is the original Server.match() implementation, used to check
+// compatibility and compare performance.
+func linearMatch(matches []*routeMatch, method, path string) (*routeMatch, Params) {
+	for _, match := range matches {
+		if method == match.route.Method {
+			matches := match.pattern.FindStringSubmatch(path)
+			if matches != nil {
+				params := Params{}
+				for i, k := range match.params {
+					params[k] = matches[i+1]
+				}
+				return match, params
+			}
+		}
+	}
+	return nil, nil
+}
+
+func TestRouterMatchesLinear(t *testing.T) {
+	matches := makeTestRouteMatches(
+		"/",
+		"/users",
+		"/users/{id:\\d+}",
+		"/users/{id}/avatar",
+		"/users/{id}/files/{path:.+}",
+		"/files/{name}.txt",
+		"/v{version:[0-9]\\{1,2\\}}/status",
+		"/a.b",
+		"/search/{q:[^/]*}",
+	)
+	r := newRouter(matches)
+	for _, path := range []string{
+		"/", "", "/users", "/users/", "/users/123", "/users/abc", "/users/123/avatar",
+		"/users/x/files/a/b/c.txt", "/users/x/files/", "/files/readme.txt", "/files/readme.md",
+		"/v1/status", "/v123/status", "/a.b", "/axb", "/search/", "/search/foo", "/search/foo/bar",
+	} {
+		expected, expectedParams := linearMatch(matches, "GET", path)
+		actual, actualParams := r.match("GET", path)
+		assert.Equal(t, expected, actual, path)
+		assert.Equal(t, expectedParams, actualParams, path)
+	}
+}
+
+func TestRouterLiteralBeatsParameter(t *testing.T) {
+	matches := makeTestRouteMatches("/users/{id}", "/users/me")
+	r := newRouter(matches)
+	match, params := r.match("GET", "/users/me")
+	assert.Equal(t, matches[1], match)
+	assert.Equal(t, Params{}, params)
+	match, params = r.match("GET", "/users/bob")
+	assert.Equal(t, matches[0], match)
+	assert.Equal(t, Params{"id": "bob"}, params)
+}
+
+func TestRouterBacktracks(t *testing.T) {
+	matches := makeTestRouteMatches("/users/me/avatar", "/users/{id}/friends")
+	r := newRouter(matches)
+	match, params := r.match("GET", "/users/me/friends")
+	assert.Equal(t, matches[1], match)
+	assert.Equal(t, Params{"id": "me"}, params)
+}
+
+func TestRouterMethod(t *testing.T) {
+	matches := makeTestRouteMatches("/users/{id}", "/users/{id}")
+	matches[1].route.Method = "DELETE"
+	r := newRouter(matches)
+	match, _ := r.match("DELETE", "/users/1")
+	assert.Equal(t, matches[1], match)
+	match, _ = r.match("PUT", "/users/1")
+	assert.Nil(t, match)
+}
+
+func TestCanMatchSlash(t *testing.T) {
+	assert.False(t, canMatchSlash(`\d+`))
+	assert.False(t, canMatchSlash(`[^/]+`))
+	assert.False(t, canMatchSlash(`a|b`))
+	assert.True(t, canMatchSlash(`.+`))
+	assert.True(t, canMatchSlash(`[a-z/]+`))
+	assert.True(t, canMatchSlash(`\S+`))
+	assert.True(t, canMatchSlash(`(`))
+}
+
+func makeBenchmarkRouteMatches() []*routeMatch {
+	paths := []string{}
+	for i := 0; i < 100; i++ {
+		paths = append(paths,
+			fmt.Sprintf("/resource%d", i),
+			fmt.Sprintf("/resource%d/{id:\\d+}", i),
+			fmt.Sprintf("/resource%d/{id}/children", i),
+		)
+	}
+	return makeTestRouteMatches(paths...)
+}
+
+func BenchmarkRouterMatch(b *testing.B) {
+	r := newRouter(makeBenchmarkRouteMatches())
+	req, _ := http.NewRequest("GET", "/resource99/1234/children", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if match, _ := r.match(req.Method, req.URL.Path); match == nil {
+			b.Fatal("no match")
+		}
+	}
+}
+
+func BenchmarkLinearMatch(b *testing.B) {
+	matches := makeBenchmarkRouteMatches()
+	req, _ := http.NewRequest("GET", "/resource99/1234/children", nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if match, _ := linearMatch(matches, req.Method, req.URL.Path); match == nil {
+			b.Fatal("no match")
+		}
+	}
+}
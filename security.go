@@ -0,0 +1,237 @@
+package rapid
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A Principal identifies the caller of a secured route, as returned by the
+// Authenticator that accepted its credentials. It is injected into handlers
+// both as a Principal and as its concrete type.
+type Principal interface{}
+
+// SecuritySchemeSchema describes a security scheme in generated RAML.
+type SecuritySchemeSchema struct {
+	Name            string   `json:"name"`
+	Type            string   `json:"type"` // RAML type, eg. "Basic Authentication" or "x-api-key".
+	Description     string   `json:"description,omitempty"`
+	Headers         []string `json:"headers,omitempty"`          // Headers carrying credentials.
+	QueryParameters []string `json:"query_parameters,omitempty"` // Query parameters carrying credentials.
+}
+
+// An Authenticator authenticates requests to routes secured by a security
+// scheme.
+type Authenticator interface {
+	// Authenticate returns the principal identified by the request's
+	// credentials, or an error if they are missing or invalid. *HTTPStatus
+	// errors are sent to the client as is, eg. to respond with 403.
+	Authenticate(r *http.Request) (Principal, error)
+	// Challenge returns the WWW-Authenticate header sent with 401 responses.
+	Challenge() string
+	// Describe the scheme for generated RAML. Name is filled in by the server.
+	Describe() *SecuritySchemeSchema
+}
+
+// A CredentialsFunc checks credentials and returns the principal they
+// identify, or an error if they are invalid.
+type CredentialsFunc func(credentials string) (Principal, error)
+
+var errNoCredentials = errors.New("no credentials")
+
+// SecurityScheme registers an Authenticator for the security scheme name.
+// Requests to routes that list security schemes with SecuredBy() are
+// rejected with 401 unless one of the schemes authenticates them, including
+// when none of the listed schemes are registered.
+//
+// The scheme is added to the server's schema, and is documented in RAML
+// generated from it.
+func (s *Server) SecurityScheme(name string, authenticator Authenticator) *Server {
+	s.authenticators[name] = authenticator
+	scheme := authenticator.Describe()
+	scheme.Name = name
+	for i, existing := range s.schema.SecuritySchemes {
+		if existing.Name == name {
+			s.schema.SecuritySchemes[i] = scheme
+			return s
+		}
+	}
+	s.schema.SecuritySchemes = append(s.schema.SecuritySchemes, scheme)
+	return s
+}
+
+// authenticate a request with the security schemes of its route. On failure
+// WWW-Authenticate headers are set and a 401 error is returned, unless an
+// Authenticator returns an *HTTPStatus error.
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request, route *RouteSchema) (Principal, error) {
+	challenges := []string{}
+	for _, name := range route.SecuredBy {
+		authenticator, ok := s.authenticators[name]
+		if !ok {
+			continue
+		}
+		principal, err := authenticator.Authenticate(r)
+		if err == nil {
+			return principal, nil
+		}
+		if status, ok := err.(*HTTPStatus); ok {
+			return nil, status
+		}
+		if err != errNoCredentials {
+			s.log.Debugf("%s %s: %s authentication failed: %s", r.Method, r.URL, name, err)
+		}
+		challenges = append(challenges, authenticator.Challenge())
+	}
+	for _, challenge := range challenges {
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+	return nil, ErrorForStatus(http.StatusUnauthorized)
+}
+
+// A ScopedPrincipal is a Principal that has been granted scopes, such as
+// *JWTClaims. Principals of routes that require scopes must implement it.
+type ScopedPrincipal interface {
+	Scopes() []string
+}
+
+// checkScopes returns a 403 error unless principal has been granted all of
+// scopes.
+func checkScopes(principal Principal, scopes []string) error {
+	if len(scopes) == 0 {
+		return nil
+	}
+	granted := []string{}
+	if scoped, ok := principal.(ScopedPrincipal); ok {
+		granted = scoped.Scopes()
+	}
+	for _, scope := range scopes {
+		if !containsString(granted, scope) {
+			return Error(http.StatusForbidden, fmt.Sprintf("missing scope %q", scope))
+		}
+	}
+	return nil
+}
+
+// securityQueryParameters returns the query parameters carrying credentials
+// for the security schemes of a route.
+func (s *Server) securityQueryParameters(route *RouteSchema) []string {
+	params := []string{}
+	for _, scheme := range s.schema.SecuritySchemes {
+		if containsString(route.SecuredBy, scheme.Name) {
+			params = append(params, scheme.QueryParameters...)
+		}
+	}
+	return params
+}
+
+type basicAuthenticator struct {
+	realm string
+	check func(username, password string) (Principal, error)
+}
+
+// BasicAuth authenticates requests with HTTP Basic authentication.
+func BasicAuth(realm string, check func(username, password string) (Principal, error)) Authenticator {
+	return &basicAuthenticator{realm, check}
+}
+
+func (b *basicAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errNoCredentials
+	}
+	return b.check(username, password)
+}
+
+func (b *basicAuthenticator) Challenge() string {
+	return fmt.Sprintf("Basic realm=%q", b.realm)
+}
+
+func (b *basicAuthenticator) Describe() *SecuritySchemeSchema {
+	return &SecuritySchemeSchema{
+		Type:        "Basic Authentication",
+		Description: "HTTP Basic authentication.",
+		Headers:     []string{"Authorization"},
+	}
+}
+
+type bearerAuthenticator struct {
+	realm string
+	check CredentialsFunc
+}
+
+// BearerAuth authenticates requests with a bearer token in the Authorization
+// header.
+func BearerAuth(realm string, check CredentialsFunc) Authenticator {
+	return &bearerAuthenticator{realm, check}
+}
+
+func (b *bearerAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return nil, errNoCredentials
+	}
+	return b.check(strings.TrimSpace(auth[7:]))
+}
+
+func (b *bearerAuthenticator) Challenge() string {
+	return fmt.Sprintf("Bearer realm=%q", b.realm)
+}
+
+func (b *bearerAuthenticator) Describe() *SecuritySchemeSchema {
+	return &SecuritySchemeSchema{
+		Type:        "x-bearer",
+		Description: "Bearer token in the Authorization header.",
+		Headers:     []string{"Authorization"},
+	}
+}
+
+type apiKeyAuthenticator struct {
+	name  string
+	query bool
+	check CredentialsFunc
+}
+
+// APIKeyHeader authenticates requests with an API key in the named header.
+func APIKeyHeader(name string, check CredentialsFunc) Authenticator {
+	return &apiKeyAuthenticator{name: name, check: check}
+}
+
+// APIKeyQuery authenticates requests with an API key in the named query
+// parameter.
+func APIKeyQuery(name string, check CredentialsFunc) Authenticator {
+	return &apiKeyAuthenticator{name: name, query: true, check: check}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (Principal, error) {
+	var key string
+	if a.query {
+		key = r.URL.Query().Get(a.name)
+	} else {
+		key = r.Header.Get(a.name)
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	return a.check(key)
+}
+
+func (a *apiKeyAuthenticator) Challenge() string {
+	in := "header"
+	if a.query {
+		in = "query"
+	}
+	return fmt.Sprintf("APIKey in=%q, name=%q", in, a.name)
+}
+
+func (a *apiKeyAuthenticator) Describe() *SecuritySchemeSchema {
+	scheme := &SecuritySchemeSchema{Type: "x-api-key"}
+	if a.query {
+		scheme.Description = fmt.Sprintf("API key in the %s query parameter.", a.name)
+		scheme.QueryParameters = []string{a.name}
+	} else {
+		scheme.Description = fmt.Sprintf("API key in the %s header.", a.name)
+		scheme.Headers = []string{a.name}
+	}
+	return scheme
+}
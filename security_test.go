@@ -0,0 +1,140 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testUser struct {
+	Name string
+}
+
+type testSecurityServer struct{}
+
+func (t *testSecurityServer) Secret(user *testUser, principal Principal, ctx context.Context) (*indexResponse, error) {
+	if principal != user || PrincipalFromContext(ctx) != principal {
+		return nil, fmt.Errorf("principal mismatch")
+	}
+	return &indexResponse{len(user.Name)}, nil
+}
+
+func (t *testSecurityServer) Public() (*indexResponse, error) {
+	return &indexResponse{0}, nil
+}
+
+func (t *testSecurityServer) Query(query *indexRequest) (*indexResponse, error) {
+	return &indexResponse{query.ID}, nil
+}
+
+func (t *testSecurityServer) Unregistered() (*indexResponse, error) {
+	return &indexResponse{0}, nil
+}
+
+func checkTestCredentials(credentials string) (Principal, error) {
+	switch credentials {
+	case "secret":
+		return &testUser{"alice"}, nil
+	case "banned":
+		return nil, ErrorForStatus(http.StatusForbidden)
+	}
+	return nil, fmt.Errorf("invalid credentials")
+}
+
+// registerTestSecuritySchemes registers the schemes used by the security
+// tests with svr.
+func registerTestSecuritySchemes(svr *Server) *Server {
+	return svr.
+		SecurityScheme("basic", BasicAuth("test", func(username, password string) (Principal, error) {
+			if username != "alice" {
+				return nil, fmt.Errorf("unknown user")
+			}
+			return checkTestCredentials(password)
+		})).
+		SecurityScheme("bearer", BearerAuth("test", checkTestCredentials)).
+		SecurityScheme("key", APIKeyHeader("X-API-Key", checkTestCredentials)).
+		SecurityScheme("query", APIKeyQuery("api_key", checkTestCredentials))
+}
+
+func TestSecurityUnauthenticated(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Secret", "/secret").Get().SecuredBy("basic", "bearer", "key").Response(200, &indexResponse{})
+	svc.Route("Public", "/public").Get().Response(200, &indexResponse{})
+	svc.Route("Unregistered", "/unregistered").Get().SecuredBy("oauth").Response(200, &indexResponse{})
+	svr := registerTestSecuritySchemes(newTestServer(t, svc.Build(), &testSecurityServer{}))
+	w := serveTestRequest(svr, "GET", "/secret", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, []string{`Basic realm="test"`, `Bearer realm="test"`, `APIKey in="header", name="X-API-Key"`}, w.Header()["Www-Authenticate"])
+
+	w = serveTestRequest(svr, "GET", "/secret", "", http.Header{"Authorization": {"Bearer wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = serveTestRequest(svr, "GET", "/public", "", nil)
+	assert.Equal(t, 200, w.Code)
+
+	// Routes secured by unregistered schemes are never authenticated.
+	w = serveTestRequest(svr, "GET", "/unregistered", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSecurityAuthenticated(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Secret", "/secret").Get().SecuredBy("basic", "bearer", "key").Response(200, &indexResponse{})
+	svc.Route("Query", "/query").Get().Query(&indexRequest{}).SecuredBy("query").Response(200, &indexResponse{})
+	svr := registerTestSecuritySchemes(newTestServer(t, svc.Build(), &testSecurityServer{}))
+	r, _ := http.NewRequest("GET", "/secret", nil)
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"ID\":5}\n", w.Body.String())
+
+	w = serveTestRequest(svr, "GET", "/secret", "", http.Header{"Authorization": {"Bearer secret"}})
+	assert.Equal(t, 200, w.Code)
+
+	w = serveTestRequest(svr, "GET", "/secret", "", http.Header{"X-Api-Key": {"secret"}})
+	assert.Equal(t, 200, w.Code)
+
+	w = serveTestRequest(svr, "GET", "/query?api_key=secret&ID=3", "", nil)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "{\"ID\":3}\n", w.Body.String())
+}
+
+func TestSecurityAuthenticatorStatus(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Secret", "/secret").Get().SecuredBy("basic", "bearer", "key").Response(200, &indexResponse{})
+	svr := registerTestSecuritySchemes(newTestServer(t, svc.Build(), &testSecurityServer{}))
+	w := serveTestRequest(svr, "GET", "/secret", "", http.Header{"Authorization": {"Bearer banned"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestSecurityRateLimitByPrincipal(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Secret", "/secret").Get().SecuredBy("basic", "bearer", "key").Response(200, &indexResponse{})
+	svr := registerTestSecuritySchemes(newTestServer(t, svc.Build(), &testSecurityServer{})).RateLimit(1, 1).RateLimitKey(RateLimitByPrincipal)
+	w := serveTestRequest(svr, "GET", "/secret", "", http.Header{"Authorization": {"Bearer secret"}})
+	assert.Equal(t, 200, w.Code)
+	w = serveTestRequest(svr, "GET", "/secret", "", http.Header{"X-Api-Key": {"secret"}})
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestSecurityRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Secret", "/secret").Get().SecuredBy("basic", "bearer", "key").Response(200, &indexResponse{})
+	svc.Route("Query", "/query").Get().Query(&indexRequest{}).SecuredBy("query").Response(200, &indexResponse{})
+	svr := registerTestSecuritySchemes(newTestServer(t, svc.Build(), &testSecurityServer{}))
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svr.schema, w)
+	assert.NoError(t, err)
+	raml := w.String()
+	assert.Contains(t, raml, "securitySchemes:\n")
+	assert.Contains(t, raml, "- basic:\n")
+	assert.Contains(t, raml, "type: Basic Authentication\n")
+	assert.Contains(t, raml, "api_key:\n")
+	assert.Regexp(t, `securedBy:\n\s*- basic\n\s*- bearer\n\s*- key\n`, raml)
+}
@@ -1,25 +1,40 @@
 package rapid
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"reflect"
 	"regexp"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/codegangsta/inject"
 	structschema "github.com/gorilla/schema"
 )
 
-var schemadecoder *structschema.Decoder
+var (
+	schemadecoder *structschema.Decoder
+	headerdecoder *structschema.Decoder
+)
 
 func init() {
 	schemadecoder = structschema.NewDecoder()
 	schemadecoder.RegisterConverter(time.Duration(0), convertDuration)
 	schemadecoder.RegisterConverter(time.Time{}, convertTime)
+	// Requests carry many headers that are of no interest to handlers.
+	headerdecoder = structschema.NewDecoder()
+	headerdecoder.SetAliasTag("header")
+	headerdecoder.IgnoreUnknownKeys(true)
+	headerdecoder.RegisterConverter(time.Duration(0), convertDuration)
+	headerdecoder.RegisterConverter(time.Time{}, convertTime)
 }
 
 func convertDuration(value string) reflect.Value {
@@ -54,14 +69,19 @@ func (l *loggerSink) Infof(fmt string, args ...interface{})    {}
 func (l *loggerSink) Warningf(fmt string, args ...interface{}) {}
 func (l *loggerSink) Errorf(fmt string, args ...interface{})   {}
 
+// CloseNotifierChannel is injected into handlers and receives a value when
+// the client disconnects.
+//
+// Deprecated: Inject a context.Context instead.
 type CloseNotifierChannel <-chan bool
 
 // An error-conformant type that can return a HTTP status code, a message, and
 // optional headers.
 type HTTPStatus struct {
-	Status  int         `json:"status"`
-	Message string      `json:"error"`
-	Headers http.Header `json:"-"`
+	Status    int         `json:"status"`
+	Message   string      `json:"error"`
+	Headers   http.Header `json:"-"`
+	RequestID RequestID   `json:"request_id,omitempty"` // Set by clients from error responses.
 }
 
 func (h *HTTPStatus) Error() string {
@@ -77,11 +97,11 @@ func Error(status int, message string) error {
 }
 
 func ErrorForStatusWithHeaders(status int, headers http.Header) error {
-	return &HTTPStatus{status, http.StatusText(status), headers}
+	return &HTTPStatus{Status: status, Message: http.StatusText(status), Headers: headers}
 }
 
 func ErrorWithHeaders(status int, message string, headers http.Header) error {
-	return &HTTPStatus{status, message, headers}
+	return &HTTPStatus{Status: status, Message: message, Headers: headers}
 }
 
 type Params map[string]string
@@ -102,11 +122,21 @@ func (p Params) Float(key string) (float64, error) {
 	return strconv.ParseFloat(v, 64)
 }
 
+// RequestMethod is injected into handlers and contains the HTTP method of the
+// request. It is primarily useful for routes defined with Any().
+type RequestMethod string
+
 type routeMatch struct {
-	route   *RouteSchema
-	pattern *regexp.Regexp
-	params  []string
-	method  reflect.Value
+	route    *RouteSchema
+	resource *ResourceSchema
+	pattern  *regexp.Regexp
+	params   []string
+	method   reflect.Value
+	handler  Handler
+	cors     *CORSSchema
+
+	maxBodySize int64        // 0 if unlimited.
+	limiter     *rateLimiter // Nil if unlimited.
 }
 
 // A function with the signature f(...) error. Arguments can be injected.
@@ -115,15 +145,34 @@ type BeforeHandlerFunc interface{}
 // A function with the signature f(...) error. Arguments can be injected.
 type AfterHandlerFunc interface{}
 
+// A function called with the request, the recovered value and a stack trace
+// when a handler panics.
+type PanicHandlerFunc func(r *http.Request, v interface{}, stack []byte)
+
 type Server struct {
 	schema        *Schema
 	matches       []*routeMatch
+	router        *router
 	codec         CodecFactory
 	log           Logger
 	Injector      inject.Injector
 	handler       interface{}
 	beforeHandler BeforeHandlerFunc
 	afterHandler  AfterHandlerFunc
+	panicHandler  PanicHandlerFunc
+	timeout       time.Duration
+	codecs        []*mediaTypeCodec
+	compression   *compressionConfig
+	rateLimitKey  RateLimitKeyFunc
+	metrics       *Metrics
+	tracer        SpanExporter
+	accessLog     *accessLogger
+
+	authenticators map[string]Authenticator
+
+	middleware         []Middleware
+	resourceMiddleware map[string][]Middleware
+	routeMiddleware    map[string][]Middleware
 }
 
 func NewServer(schema *Schema, handler interface{}) (*Server, error) {
@@ -136,22 +185,44 @@ func NewServer(schema *Schema, handler interface{}) (*Server, error) {
 			if !method.IsValid() {
 				return nil, fmt.Errorf("no such method %s.%s", hr.Type(), route.Name)
 			}
+			if err := checkHandlerMethod(route, method.Type()); err != nil {
+				return nil, fmt.Errorf("handler method %s.%s %s", hr.Type(), route.Name, err)
+			}
+			if err := checkHandlerArguments(schema, route, method.Type()); err != nil {
+				return nil, fmt.Errorf("handler method %s.%s %s", hr.Type(), route.Name, err)
+			}
+			for _, t := range []reflect.Type{route.PathType, route.QueryType, route.HeaderType, route.RequestType} {
+				if err := checkConstraints(t); err != nil {
+					return nil, fmt.Errorf("route %s: %s", route.Name, err)
+				}
+			}
 			matches = append(matches, &routeMatch{
-				route:   route,
-				pattern: pattern,
-				params:  params,
-				method:  method,
+				route:    route,
+				resource: resource,
+				pattern:  pattern,
+				params:   params,
+				method:   method,
+				cors:     resolveCORS(schema, resource, route),
+
+				maxBodySize: schema.MaxBodySizeFor(route),
+				limiter:     newRateLimiter(route.RateLimit),
 			})
 		}
 	}
 	s := &Server{
 		schema:   schema,
 		matches:  matches,
+		router:   newRouter(matches),
 		codec:    DefaultCodecFactory,
 		log:      &loggerSink{},
 		Injector: inject.New(),
 		handler:  handler,
+
+		authenticators:     map[string]Authenticator{},
+		resourceMiddleware: map[string][]Middleware{},
+		routeMiddleware:    map[string][]Middleware{},
 	}
+	s.buildHandlers()
 	return s, nil
 }
 
@@ -180,6 +251,14 @@ func (s *Server) AfterHandler(after AfterHandlerFunc) *Server {
 
 }
 
+// Register a function to be called when a handler panics. The panic is
+// always logged and a 500 response encoded with the server's codec; this is
+// for additional reporting.
+func (s *Server) PanicHandler(handler PanicHandlerFunc) *Server {
+	s.panicHandler = handler
+	return s
+}
+
 // Close underlying handler if it supports io.Closer.
 func (s *Server) Close() error {
 	if closer, ok := s.handler.(io.Closer); ok {
@@ -196,15 +275,167 @@ func indirect(t reflect.Type) reflect.Type {
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	recorder := newRecordingResponseWriter(w)
+	w = recorder
+	var logged *accessLogEntry
+	if s.accessLog != nil {
+		logged, r = s.accessLog.begin(recorder, r, s.log)
+		defer logged.end()
+	}
+	var measured *requestMetrics
+	if s.metrics != nil {
+		measured = s.metrics.begin(recorder, r)
+		defer measured.end()
+	}
+	var span *Span
+	if s.tracer != nil {
+		span, r = s.startSpan(r)
+		defer s.endSpan(span, recorder)
+	}
+
 	s.log.Debugf("%s %s", r.Method, r.URL)
+	// Compressed responses are closed after recoverPanic, which responds
+	// directly to the recorder, so that a panicking handler's buffered output
+	// is discarded rather than flushed.
+	var compressed *compressResponseWriter
+	defer func() {
+		if compressed != nil && (compressed.decided || recorder.status == 0) {
+			s.maybeLogError(compressed.Close())
+		}
+	}()
+	defer s.recoverPanic(recorder, r)
+
+	if s.servePreflight(w, r) {
+		return
+	}
 
 	// Match URL and method.
-	match, parts := s.match(r)
+	match, parts, head := s.match(r)
+	if head {
+		w = &headResponseWriter{w}
+	}
 	if match == nil {
-		s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusNotFound, nil))
+		allowed := s.router.methods(r.URL.Path)
+		switch {
+		case len(allowed) == 0:
+			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusNotFound, nil))
+			return
+
+		case r.Method == "OPTIONS":
+			w.Header().Set("Allow", strings.Join(allowed, ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		default:
+			err := ErrorForStatusWithHeaders(http.StatusMethodNotAllowed, http.Header{
+				"Allow": {strings.Join(allowed, ", ")},
+			})
+			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, err))
+			return
+		}
+	}
+
+	if measured != nil {
+		measured.match(match.route)
+	}
+	if span != nil {
+		span.Name = match.route.Name
+	}
+	if logged != nil {
+		logged.record.Route = match.route.Name
+	}
+
+	applyCORS(match.cors, w, r)
+
+	ctx, cancel := s.requestContext(r, match, parts)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	// Rate limit callers that can be identified before authentication, so
+	// that unauthenticated requests are limited too.
+	rateLimited := false
+	if match.limiter != nil {
+		if key := s.rateLimitKeyOf(r); key != "" {
+			if !s.applyRateLimit(w, match.limiter, key) {
+				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, errRateLimitExceeded))
+				return
+			}
+			rateLimited = true
+		}
+	}
+
+	// Authenticate the caller of secured routes.
+	var principal Principal
+	if len(match.route.SecuredBy) > 0 {
+		var err error
+		principal, err = s.authenticate(w, r, match.route)
+		if err != nil {
+			// Callers that fail to authenticate are limited by IP.
+			if match.limiter != nil && !rateLimited && !s.applyRateLimit(w, match.limiter, RateLimitByIP(r)) {
+				err = errRateLimitExceeded
+			}
+			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, err))
+			return
+		}
+		ctx = context.WithValue(ctx, principalContextKey, principal)
+		r = r.WithContext(ctx)
+		if logged != nil {
+			logged.principal(principal)
+		}
+	}
+	// Scopes are checked even if the route is not secured, so that routes
+	// missing SecuredBy() fail closed.
+	if err := checkScopes(principal, match.route.Scopes); err != nil {
+		s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, err))
+		return
+	}
+
+	// Rate limit callers identified by their principal.
+	if match.limiter != nil && !rateLimited {
+		key := s.rateLimitKeyOf(r)
+		if key == "" {
+			key = RateLimitByIP(r)
+		}
+		if !s.applyRateLimit(w, match.limiter, key) {
+			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, errRateLimitExceeded))
+			return
+		}
+	}
+
+	// Negotiate the response codec.
+	if len(s.codecs) > 0 {
+		w.Header().Add("Vary", "Accept")
+	}
+	codec, err := s.responseCodec(r, match.route)
+	if err != nil {
+		s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, err))
 		return
 	}
 
+	// Compress the response.
+	if s.compression != nil && match.route.WebSocket == nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+			compressed = newCompressResponseWriter(w, s.compression, encoding)
+			w = compressed
+		}
+	}
+
+	// Limit the size of the request body, before and after decompression.
+	if match.maxBodySize > 0 && r.Body != nil {
+		if r.ContentLength > match.maxBodySize {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, ErrorForStatus(http.StatusRequestEntityTooLarge)))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, match.maxBodySize)
+	}
+	if r.Body != nil {
+		if err := decompressRequest(r, match.maxBodySize); err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, err))
+			return
+		}
+	}
+
 	i := inject.New()
 	i.SetParent(s.Injector)
 
@@ -217,46 +448,110 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 		err := schemadecoder.Decode(path, values)
 		if err != nil {
-			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationPath, err)))
+			return
+		}
+		if err := validateConstraints(LocationPath, path, valuesPresence(values)); err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
 			return
 		}
 		if v, ok := path.(Validator); ok {
 			if err := v.Validate(); err != nil {
-				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationPath, err)))
 				return
 			}
 		}
 		i.Map(path)
 	}
 
+	// Decode page parameters, if any.
+	var page *Page
+	if match.route.Pagination != nil {
+		var err error
+		page, err = parsePage(match.route.Pagination, r.URL.Query())
+		if err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+			return
+		}
+		i.Map(page)
+	}
+
 	// Decode query parameters, if any.
 	if match.route.QueryType != nil {
 		query := reflect.New(indirect(match.route.QueryType)).Interface()
-		err := schemadecoder.Decode(query, r.URL.Query())
+		values := r.URL.Query()
+		if match.route.Pagination != nil {
+			for _, param := range match.route.Pagination.params() {
+				values.Del(param)
+			}
+		}
+		for _, param := range s.securityQueryParameters(match.route) {
+			values.Del(param)
+		}
+		err := schemadecoder.Decode(query, values)
 		if err != nil {
-			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationQuery, err)))
+			return
+		}
+		if err := validateConstraints(LocationQuery, query, valuesPresence(values)); err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
 			return
 		}
 		if v, ok := query.(Validator); ok {
 			if err := v.Validate(); err != nil {
-				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationQuery, err)))
 				return
 			}
 		}
 		i.Map(query)
 	}
 
+	// Decode headers, if any.
+	if match.route.HeaderType != nil {
+		headers := reflect.New(indirect(match.route.HeaderType)).Interface()
+		values := url.Values(r.Header)
+		err := headerdecoder.Decode(headers, values)
+		if err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationHeader, err)))
+			return
+		}
+		if err := validateConstraints(LocationHeader, headers, valuesPresence(values)); err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+			return
+		}
+		if v, ok := headers.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationHeader, err)))
+				return
+			}
+		}
+		i.Map(headers)
+	}
+
 	// Decode request body, if any.
 	if match.route.RequestType != nil {
 		req, reqi := makeValueAndInterface(match.route.RequestType)
-		err := s.codec.Request(reqi).DecodeRequest(r)
+		requestCodec, err := s.requestCodec(r, reqi)
+		if err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, err))
+			return
+		}
+		err = requestCodec.Request(reqi).DecodeRequest(r)
+		if isBodyTooLarge(err) {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, ErrorForStatus(http.StatusRequestEntityTooLarge)))
+			return
+		}
 		if err != nil {
-			s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationBody, err)))
+			return
+		}
+		if err := validateConstraints(LocationBody, reqi, nil); err != nil {
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
 			return
 		}
 		if v, ok := reqi.(Validator); ok {
 			if err := v.Validate(); err != nil {
-				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, err))
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, http.StatusBadRequest, newValidationError(LocationBody, err)))
 				return
 			}
 		}
@@ -266,98 +561,183 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	i.MapTo(i, (*inject.Injector)(nil))
 	i.MapTo(w, (*http.ResponseWriter)(nil))
 	i.Map(r)
+	i.MapTo(ctx, (*context.Context)(nil))
+	i.Map(RequestMethod(r.Method))
 	i.Map(parts)
 	i.Map(match.route)
+	i.Map(newPreconditions(r))
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		i.Map(sc)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		i.Map(id)
+	}
+	if principal != nil {
+		i.MapTo(principal, (*Principal)(nil))
+		i.Map(principal)
+	}
+	if response := match.route.DefaultResponse(); response != nil && response.Streaming {
+		i.Map(LastEventID(r.Header.Get("Last-Event-ID")))
+	}
 
-	var closeNotifier CloseNotifierChannel
 	if cn, ok := w.(http.CloseNotifier); ok {
-		// WriteError(w, http.StatusInternalServerError, errors.New("HTTP writer does not support close notifications"))
-		// return
-		closeNotifier = CloseNotifierChannel(cn.CloseNotify())
-		i.Map(closeNotifier)
+		i.Map(CloseNotifierChannel(cn.CloseNotify()))
 	}
 
 	if s.beforeHandler != nil {
 		results, err := i.Invoke(s.beforeHandler)
 		if err != nil {
-			panic(err.Error())
+			s.log.Errorf("%s %s: BeforeHandler: %s", r.Method, r.URL, err)
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, ErrorForStatus(http.StatusInternalServerError)))
+			return
 		}
 		rerr := results[0]
 		if !rerr.IsNil() {
 			err = rerr.Interface().(error)
 			if err != nil {
-				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 500, err))
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 500, err))
 				return
 			}
 		}
 	}
 
-	result, err := i.Invoke(match.method.Interface())
-	if err != nil {
-		panic(err.Error())
-	}
-	switch len(result) {
-	case 0: // Zero return values, we assume the handler has processed the request itself.
+	result, err := match.handler(i)
+	if result == responseHandled {
 		return
-
-	case 1: // Single value is always an error, so we just synthesize (nil, error).
-		result = []reflect.Value{reflect.ValueOf((*struct{})(nil)), result[0]}
-
-	case 2: // (response, error)
-		// TODO: More checks for stuff.
-
-	default:
-		panic(fmt.Errorf("handler method %s.%s should return (<response>, <error>)", match.method.Type(), match.route.Name))
 	}
 
 	if s.afterHandler != nil {
 		results, err := i.Invoke(s.afterHandler)
 		if err != nil {
-			panic(err.Error())
+			s.log.Errorf("%s %s: AfterHandler: %s", r.Method, r.URL, err)
+			s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 0, ErrorForStatus(http.StatusInternalServerError)))
+			return
 		}
 		rerr := results[0]
 		if !rerr.IsNil() {
 			err = rerr.Interface().(error)
 			if err != nil {
-				s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 500, err))
+				s.maybeLogError(codec.Response(nil).EncodeResponse(r, w, 500, err))
 				return
 			}
 		}
 	}
 
-	s.log.Debugf("%s %s -> %v", r.Method, r.URL, result[1].Interface())
-	s.handleScalar(match.route, closeNotifier, w, r, result[0], result[1])
+	s.log.Debugf("%s %s -> %v", r.Method, r.URL, err)
+	if stream, ok := result.(*streamResponse); ok {
+		s.handleStream(match.route, w, r, stream)
+		return
+	}
+	if page != nil && err == nil {
+		w.Header().Set("Link", pageLinks(r, page))
+	}
+	s.handleScalar(codec, w, r, result, err)
 }
 
-func (s *Server) handleScalar(route *RouteSchema, closeNotifier CloseNotifierChannel, w http.ResponseWriter, r *http.Request, rdata reflect.Value, rerr reflect.Value) {
-	var data interface{}
-	var err error
-	switch rdata.Kind() {
-	case reflect.String:
-		data = rdata.String()
-
-	case reflect.Int, reflect.Int16, reflect.Int32, reflect.Int64:
-		data = rdata.Int()
-
-	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		data = rdata.Uint()
+// handleStream writes each value received from the stream's data channel as
+// a flushed frame. Errors received from the stream's error channel, if any,
+// are sent as in-band error frames. Streaming stops when the data channel is
+// closed or the request's context is done.
+func (s *Server) handleStream(route *RouteSchema, w http.ResponseWriter, r *http.Request, stream *streamResponse) {
+	status := http.StatusOK
+	contentType := "application/x-ndjson"
+	response := route.DefaultResponse()
+	if response != nil {
+		status = response.Status
+		if response.ContentType != "" {
+			contentType = response.ContentType
+		}
+	}
+	encode := func(w io.Writer, v interface{}, err error) error {
+		if err != nil {
+			return s.codec.Stream(nil).EncodeFrame(w, err)
+		}
+		_, vi := valueAndInterface(v)
+		return s.codec.Stream(vi).EncodeFrame(w, nil)
+	}
+	if response != nil && response.Streaming && acceptsEventStream(r) {
+		contentType = EventStreamMediaType
+		encode = newSSEEncoder(LastEventID(r.Header.Get("Last-Event-ID"))).EncodeFrame
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	flush()
+
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: stream.data},
+		{Dir: reflect.SelectRecv, Chan: stream.errors},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(r.Context().Done())},
+	}
+	for {
+		chosen, v, ok := reflect.Select(cases)
+		var err error
+		switch chosen {
+		case 0: // Data.
+			if !ok {
+				return
+			}
+			err = encode(w, v.Interface(), nil)
 
-	case reflect.Float32, reflect.Float64:
-		data = rdata.Float()
+		case 1: // Errors.
+			if !ok {
+				cases[1].Chan = reflect.Value{}
+				continue
+			}
+			if v.IsNil() {
+				continue
+			}
+			err = encode(w, nil, v.Interface().(error))
 
-	default:
-		if !rdata.IsNil() {
-			data = rdata.Interface()
+		case 2: // Client disconnected or request cancelled.
+			s.log.Debugf("%s %s: stream closed: %s", r.Method, r.URL, r.Context().Err())
+			return
+		}
+		if err != nil {
+			s.maybeLogError(err)
+			return
 		}
+		flush()
+	}
+}
 
+func (s *Server) handleScalar(codec CodecFactory, w http.ResponseWriter, r *http.Request, data interface{}, err error) {
+	_, datai := valueAndInterface(data)
+	if writeEntityHeaders(w, r, datai, err) {
+		return
 	}
+	s.maybeLogError(codec.Response(datai).EncodeResponse(r, w, 0, err))
+}
 
-	// If we have an error...
-	if !rerr.IsNil() {
-		err = rerr.Interface().(error)
+// recoverPanic must be deferred. It reports a panic in a handler and sends
+// a 500 response, unless the response has already been started.
+func (s *Server) recoverPanic(w *recordingResponseWriter, r *http.Request) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	s.reportPanic(r, v)
+	if w.status != 0 {
+		return
+	}
+	s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, ErrorForStatus(http.StatusInternalServerError)))
+}
+
+func (s *Server) reportPanic(r *http.Request, v interface{}) {
+	stack := debug.Stack()
+	s.log.Errorf("%s %s: panic: %v\n%s", r.Method, r.URL, v, stack)
+	if s.panicHandler != nil {
+		s.panicHandler(r, v, stack)
 	}
-	_, datai := valueAndInterface(data)
-	s.maybeLogError(s.codec.Response(datai).EncodeResponse(r, w, 0, err))
 }
 
 func (s *Server) maybeLogError(err error) {
@@ -366,20 +746,131 @@ func (s *Server) maybeLogError(err error) {
 	}
 }
 
-func (s *Server) match(r *http.Request) (*routeMatch, Params) {
-	for _, match := range s.matches {
-		if r.Method == match.route.Method {
-			matches := match.pattern.FindStringSubmatch(r.URL.Path)
-			if matches != nil {
-				params := Params{}
-				for i, k := range match.params {
-					params[k] = matches[i+1]
-				}
-				return match, params
-			}
+// isBodyTooLarge returns true if err was caused by reading more of a request
+// body than allowed by http.MaxBytesReader.
+func isBodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+// headResponseWriter discards the response body of HEAD requests.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (h *headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (h *headResponseWriter) Flush() {
+	if f, ok := h.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (h *headResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := h.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+func (h *headResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	return hj.Hijack()
+}
+
+// recordingResponseWriter records the status and size of a response.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func newRecordingResponseWriter(w http.ResponseWriter) *recordingResponseWriter {
+	if rw, ok := w.(*recordingResponseWriter); ok {
+		return rw
+	}
+	return &recordingResponseWriter{ResponseWriter: w}
+}
+
+// Status of the response, which is 200 if nothing has been written.
+func (r *recordingResponseWriter) Status() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+func (r *recordingResponseWriter) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recordingResponseWriter) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *recordingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recordingResponseWriter) CloseNotify() <-chan bool {
+	if cn, ok := r.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Hijack the connection, eg. for WebSockets, which are recorded with status
+// 101.
+func (r *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	if r.status == 0 {
+		r.status = http.StatusSwitchingProtocols
+	}
+	return hj.Hijack()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// match finds the route for a request. Routes with an explicit method take
+// precedence over routes matching any method, and HEAD requests are served
+// by GET routes if there is no HEAD route, in which case head is true and
+// the response body must be discarded.
+func (s *Server) match(r *http.Request) (match *routeMatch, params Params, head bool) {
+	if match, params := s.router.match(r.Method, r.URL.Path); match != nil {
+		return match, params, false
+	}
+	if r.Method == "HEAD" {
+		if match, params := s.router.match("GET", r.URL.Path); match != nil {
+			return match, params, true
 		}
 	}
-	return nil, nil
+	match, params = s.router.match(AnyMethod, r.URL.Path)
+	return match, params, false
 }
 
 // These two functions are required to handle interface methods on value
@@ -2,9 +2,12 @@ package rapid
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -35,6 +38,25 @@ func (t *testServer) Fails() error {
 	return ErrorWithHeaders(http.StatusBadRequest, "bad request", http.Header{"X-Error": {"bad request"}})
 }
 
+// newTestServer binds service to schema, failing the test if it can not.
+func newTestServer(t *testing.T, schema *Schema, service interface{}) *Server {
+	svr, err := NewServer(schema, service)
+	assert.NoError(t, err)
+	return svr
+}
+
+// serveTestRequest serves a request with the given body and headers, and
+// returns the recorded response.
+func serveTestRequest(svr http.Handler, method, path, body string, headers http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for key, values := range headers {
+		r.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	return w
+}
+
 func TestServerMethodDoesNotExist(t *testing.T) {
 	svc := Define("Test")
 	svc.Route("Invalid", "/").Get().Response(http.StatusOK, nil)
@@ -66,6 +88,14 @@ func TestServerCallsMethod(t *testing.T) {
 	assert.Equal(t, 10, test.id)
 	assert.Equal(t, 200, w.Code)
 	assert.Equal(t, "{\"ID\":20}\n", w.Body.String())
+
+	// A missing request body is an error.
+	test.called = false
+	r, _ = http.NewRequest("GET", "/hello", &bytes.Buffer{})
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.False(t, test.called)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
 func TestPatternRegex(t *testing.T) {
@@ -106,13 +136,29 @@ type testChunkedServer struct {
 	id int
 }
 
-func (t *testChunkedServer) Index(params map[string]interface{}) {
-
+func (t *testChunkedServer) Index() (chan *indexResponse, chan error) {
+	dc := make(chan *indexResponse)
+	ec := make(chan error)
+	go func() {
+		defer close(dc)
+		dc <- &indexResponse{1}
+		ec <- Error(http.StatusGatewayTimeout, "timed out")
+		dc <- &indexResponse{2}
+	}()
+	return dc, ec
 }
 
 func TestServerChunkedResponses(t *testing.T) {
 	svc := Define("Test")
-	svc.Route("Index", "/{id}").Get().Response(200, &indexResponse{})
+	svc.Route("Index", "/{id}").Get().Responses(Response(200, &indexResponse{}).Streaming())
+	svr, _ := NewServer(svc.Build(), &testChunkedServer{})
+	r, _ := http.NewRequest("GET", "/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.True(t, w.Flushed)
+	assert.Equal(t, "{\"d\":{\"ID\":1}}\n{\"e\":\"timed out\",\"s\":504}\n{\"d\":{\"ID\":2}}\n", w.Body.String())
 }
 
 type pathData struct {
@@ -201,3 +247,309 @@ func TestValueAndInterface(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, b, RawData(w.Body.Bytes()))
 }
+
+type testMethodsServer struct {
+	called string
+}
+
+func (t *testMethodsServer) Get() (*indexResponse, error) {
+	t.called = "Get"
+	return &indexResponse{1}, nil
+}
+
+func (t *testMethodsServer) Delete() error {
+	t.called = "Delete"
+	return nil
+}
+
+func (t *testMethodsServer) Options(w http.ResponseWriter) {
+	t.called = "Options"
+	w.Header().Set("Allow", "GET")
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/{id}").Get().Response(200, &indexResponse{})
+	svc.Route("Delete", "/{id}").Delete()
+	test := &testMethodsServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	r, _ := http.NewRequest("PUT", "/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "", test.called)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+}
+
+func TestAutomaticHead(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/{id}").Get().Response(200, &indexResponse{})
+	test := &testMethodsServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	r, _ := http.NewRequest("HEAD", "/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "Get", test.called)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestAutomaticOptions(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Get", "/{id}").Get().Response(200, &indexResponse{})
+	svc.Route("Delete", "/{id}").Delete()
+	svc.Route("Options", "/custom").Options()
+	test := &testMethodsServer{}
+	svr := newTestServer(t, svc.Build(), test)
+	r, _ := http.NewRequest("OPTIONS", "/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "", test.called)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "DELETE, GET, HEAD, OPTIONS", w.Header().Get("Allow"))
+
+	r, _ = http.NewRequest("OPTIONS", "/custom", nil)
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, "Options", test.called)
+	assert.Equal(t, "GET", w.Header().Get("Allow"))
+}
+
+type testAnyServer struct {
+	method RequestMethod
+}
+
+func (t *testAnyServer) Get() (*indexResponse, error) {
+	t.method = "Get"
+	return &indexResponse{1}, nil
+}
+
+func (t *testAnyServer) Any(method RequestMethod) error {
+	t.method = method
+	return nil
+}
+
+func TestAnyMethod(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Any", "/{id}").Any()
+	svc.Route("Get", "/{id}").Get().Response(200, &indexResponse{})
+	test := &testAnyServer{}
+	svr, err := NewServer(svc.Build(), test)
+	assert.NoError(t, err)
+
+	r, _ := http.NewRequest("GET", "/1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, RequestMethod("Get"), test.method)
+
+	r, _ = http.NewRequest("PATCH", "/1", nil)
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, RequestMethod("PATCH"), test.method)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// HEAD is served by the GET route in preference to the Any route.
+	r, _ = http.NewRequest("HEAD", "/1", nil)
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, RequestMethod("Get"), test.method)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestHeadResponseWriterInterfaces(t *testing.T) {
+	var w http.ResponseWriter = &headResponseWriter{httptest.NewRecorder()}
+	_, ok := w.(http.CloseNotifier)
+	assert.True(t, ok)
+	_, ok = w.(http.Hijacker)
+	assert.True(t, ok)
+	_, ok = w.(http.Flusher)
+	assert.True(t, ok)
+}
+
+type testPanicServer struct{}
+
+func (t *testPanicServer) Panics() (*indexResponse, error) {
+	panic("oops")
+}
+
+func (t *testPanicServer) Injects(req *indexRequest) error {
+	return nil
+}
+
+func (t *testPanicServer) Invalid() (*indexResponse, *indexResponse, error) {
+	return nil, nil, nil
+}
+
+type testLogger struct {
+	loggerSink
+	errors []string
+}
+
+func (t *testLogger) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestServerRecoversPanic(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Panics", "/panics").Get().Response(200, &indexResponse{})
+	svc.Route("Injects", "/injects").Get().Response(http.StatusOK, nil)
+	log := &testLogger{}
+	var recovered interface{}
+	var stack []byte
+	svr := newTestServer(t, svc.Build(), &testPanicServer{})
+	svr.Logger(log).PanicHandler(func(r *http.Request, v interface{}, s []byte) {
+		recovered, stack = v, s
+	})
+
+	r, _ := http.NewRequest("GET", "/panics", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "{\"e\":\"Internal Server Error\"}\n", w.Body.String())
+	assert.Equal(t, "oops", recovered)
+	assert.Contains(t, string(stack), "testPanicServer")
+	assert.Equal(t, 1, len(log.errors))
+	assert.Contains(t, log.errors[0], "GET /panics: panic: oops")
+
+	// Injection failures are reported as errors, not panics.
+	svr.BeforeHandler(func(req *indexRequest) error { return nil })
+	recovered = nil
+	r, _ = http.NewRequest("GET", "/injects", nil)
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 2, len(log.errors))
+	assert.Contains(t, log.errors[1], "BeforeHandler")
+	assert.Nil(t, recovered)
+}
+
+func (t *testPanicServer) TypedError(r *http.Request) (*indexResponse, *HTTPStatus) {
+	if r.URL.Query().Get("id") == "" {
+		return nil, ErrorForStatus(http.StatusNotFound).(*HTTPStatus)
+	}
+	return &indexResponse{}, nil
+}
+
+func (t *testPanicServer) PanicsAfterWriting(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusAccepted)
+	panic("oops")
+}
+
+func TestServerRecoversPanicAfterWriting(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("PanicsAfterWriting", "/").Get().Response(http.StatusAccepted, nil)
+	svr := newTestServer(t, svc.Build(), &testPanicServer{})
+	svr.Logger(&testLogger{})
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestServerTypedErrorHandler(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("TypedError", "/").Get().Response(200, &indexResponse{})
+	svr := newTestServer(t, svc.Build(), &testPanicServer{})
+	r, _ := http.NewRequest("GET", "/?id=1", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	r, _ = http.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestServerRejectsUninjectableArguments(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Panics", "/panics").Post().Request(&indexRequest{}).Response(200, &indexResponse{})
+	svc.Route("Injects", "/injects").Get().Response(http.StatusOK, nil)
+	_, err := NewServer(svc.Build(), &testPanicServer{})
+	assert.EqualError(t, err, "handler method *rapid.testPanicServer.Injects takes *rapid.indexRequest, which is not a path, query, header or request type of the route")
+}
+
+func TestServerRejectsInvalidHandlerMethod(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Invalid", "/").Get().Response(200, &indexResponse{})
+	_, err := NewServer(svc.Build(), &testPanicServer{})
+	assert.EqualError(t, err, "handler method *rapid.testPanicServer.Invalid should return (<response>, <error>)")
+}
+
+type testMaxBodySizeServer struct{}
+
+func (t *testMaxBodySizeServer) Small(req *indexRequest) error {
+	return nil
+}
+
+func (t *testMaxBodySizeServer) Large(req *indexRequest) error {
+	return nil
+}
+
+func TestMaxBodySize(t *testing.T) {
+	svc := Define("Test").MaxBodySize(16)
+	svc.Route("Small", "/small").Post().Request(&indexRequest{}).Response(http.StatusOK, nil)
+	svc.Route("Large", "/large").Post().Request(&indexRequest{}).MaxBodySize(1024).Response(http.StatusOK, nil)
+	svr, err := NewServer(svc.Build(), &testMaxBodySizeServer{})
+	assert.NoError(t, err)
+
+	r, _ := http.NewRequest("POST", "/small", bytes.NewBufferString(`{"ID": 1}`))
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	r, _ = http.NewRequest("POST", "/small", bytes.NewBufferString(`{"ID": 1000000000000}`))
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, "{\"e\":\"Request Entity Too Large\"}\n", w.Body.String())
+
+	// Bodies of unknown length are limited while decoding.
+	r, _ = http.NewRequest("POST", "/small", bytes.NewBufferString(`{"ID": 1000000000000}`))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	r, _ = http.NewRequest("POST", "/large", bytes.NewBufferString(`{"ID": 1`+strings.Repeat(" ", 1000)+`}`))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	raml := &bytes.Buffer{}
+	assert.NoError(t, SchemaToRAML("http://localhost", svc.Build(), raml))
+	assert.Contains(t, raml.String(), "Request body exceeds 1024 bytes.")
+	assert.Contains(t, raml.String(), "Request body exceeds 16 bytes.")
+}
+
+type testFileUploadServer struct{}
+
+func (t *testFileUploadServer) Upload(file *FileUpload) error {
+	_, err := ioutil.ReadAll(file.Reader)
+	return err
+}
+
+func TestMaxBodySizeFileUpload(t *testing.T) {
+	defer func(size int64) { DefaultMaxBodySize = size }(DefaultMaxBodySize)
+	DefaultMaxBodySize = 16
+
+	svc := Define("Test")
+	svc.Route("Upload", "/upload").FileUpload().Request(&FileUpload{}).Response(http.StatusOK, nil)
+	svc.Route("Upload", "/limited").FileUpload().Request(&FileUpload{}).MaxBodySize(16).Response(http.StatusOK, nil)
+	svr := newTestServer(t, svc.Build(), &testFileUploadServer{})
+
+	headers := http.Header{
+		"Content-Type":        {"text/plain"},
+		"Content-Disposition": {`attachment; filename="large.txt"`},
+	}
+	body := strings.Repeat("x", 1024)
+	// File uploads are streamed, so the default limit does not apply.
+	w := serveTestRequest(svr, "POST", "/upload", body, headers)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = serveTestRequest(svr, "POST", "/limited", body, headers)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
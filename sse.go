@@ -0,0 +1,86 @@
+package rapid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EventStreamMediaType is the media type of Server-Sent Events.
+const EventStreamMediaType = "text/event-stream"
+
+// LastEventID is injected into handlers for streaming routes. When a
+// Server-Sent Events client reconnects it contains the ID of the last event
+// the client received, otherwise it is empty.
+type LastEventID string
+
+// ServerSentEvent can be implemented by values sent on a streaming channel to
+// control the "id" and "event" fields of the corresponding Server-Sent Event.
+// Empty values fall back to the defaults.
+type ServerSentEvent interface {
+	EventID() string
+	EventName() string
+}
+
+// acceptsEventStream returns true if the client explicitly accepts
+// Server-Sent Events.
+func acceptsEventStream(r *http.Request) bool {
+	for _, accept := range r.Header["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			mt, _, err := mime.ParseMediaType(part)
+			if err == nil && mt == EventStreamMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sseEncoder encodes stream frames as Server-Sent Events.
+//
+// By default event IDs are sequential integers, continuing from the
+// Last-Event-ID of a reconnecting client if it is numeric.
+type sseEncoder struct {
+	id uint64
+}
+
+func newSSEEncoder(lastEventID LastEventID) *sseEncoder {
+	id, _ := strconv.ParseUint(string(lastEventID), 10, 64)
+	return &sseEncoder{id: id}
+}
+
+func (s *sseEncoder) EncodeFrame(w io.Writer, v interface{}, err error) error {
+	s.id++
+	id := strconv.FormatUint(s.id, 10)
+	event := "message"
+	var data interface{} = v
+	if err != nil {
+		status, err := inferStatus(nil, 0, err)
+		event = "error"
+		data = &StreamFrame{Error: err.Error(), Status: status}
+	} else if e, ok := v.(ServerSentEvent); ok {
+		if e.EventID() != "" {
+			id = e.EventID()
+		}
+		if e.EventName() != "" {
+			event = e.EventName()
+		}
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "id: %s\nevent: %s\n", id, event)
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		fmt.Fprintf(buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
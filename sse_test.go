@@ -0,0 +1,71 @@
+package rapid
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testSSEEvent struct {
+	ID int
+}
+
+func (t *testSSEEvent) EventID() string   { return "" }
+func (t *testSSEEvent) EventName() string { return "changed" }
+
+type testSSEServer struct {
+	lastEventID LastEventID
+}
+
+func (t *testSSEServer) Index(lastEventID LastEventID) (chan *testSSEEvent, chan error) {
+	t.lastEventID = lastEventID
+	dc := make(chan *testSSEEvent)
+	ec := make(chan error)
+	go func() {
+		defer close(dc)
+		dc <- &testSSEEvent{1}
+		ec <- Error(http.StatusGatewayTimeout, "timed out")
+	}()
+	return dc, ec
+}
+
+func TestServerSentEvents(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/").Get().Responses(Response(200, &testSSEEvent{}).Streaming())
+	test := &testSSEServer{}
+	svr, _ := NewServer(svc.Build(), test)
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	r.Header.Set("Last-Event-ID", "41")
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, LastEventID("41"), test.lastEventID)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Equal(t, "id: 42\nevent: changed\ndata: {\"ID\":1}\n\n"+
+		"id: 43\nevent: error\ndata: {\"e\":\"timed out\",\"s\":504}\n\n", w.Body.String())
+}
+
+func TestServerSentEventsNotAccepted(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/").Get().Responses(Response(200, &testSSEEvent{}).Streaming())
+	test := &testSSEServer{}
+	svr, _ := NewServer(svc.Build(), test)
+	r, _ := http.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, LastEventID(""), test.lastEventID)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+}
+
+func TestServerSentEventsRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Index", "/").Get().Responses(Response(200, &testSSEEvent{}).Streaming())
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svc.Build(), w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "text/event-stream:")
+}
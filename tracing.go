@@ -0,0 +1,297 @@
+package rapid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A TraceID identifies a trace across services.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid returns true if the ID is not all zeros.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) MarshalText() ([]byte, error) {
+	if !t.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(t.String()), nil
+}
+
+// A SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid returns true if the ID is not all zeros.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) MarshalText() ([]byte, error) {
+	if !s.IsValid() {
+		return []byte{}, nil
+	}
+	return []byte(s.String()), nil
+}
+
+// TraceFlags of a W3C Trace Context.
+type TraceFlags byte
+
+// TraceFlagSampled indicates that the caller may have recorded the trace.
+const TraceFlagSampled TraceFlags = 0x01
+
+// SpanContext identifies a span for propagation across services with the W3C
+// Trace Context "traceparent" and "tracestate" headers.
+//
+// The SpanContext of the span created for a request is injected into
+// handlers, and is available with SpanContextFromContext().
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   TraceFlags
+	State   string // Vendor-specific "tracestate", propagated as is.
+}
+
+// IsValid returns true if the trace and span IDs are valid.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID.IsValid() && s.SpanID.IsValid()
+}
+
+// Sampled returns true if the sampled flag is set.
+func (s SpanContext) Sampled() bool {
+	return s.Flags&TraceFlagSampled != 0
+}
+
+// TraceParent formats the span context as a "traceparent" header.
+func (s SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", s.TraceID, s.SpanID, byte(s.Flags))
+}
+
+// Inject the span context into the headers of an outgoing request.
+func (s SpanContext) Inject(header http.Header) {
+	header.Set("traceparent", s.TraceParent())
+	if s.State != "" {
+		header.Set("tracestate", s.State)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// ExtractSpanContext parses the "traceparent" and "tracestate" headers of an
+// incoming request.
+func ExtractSpanContext(header http.Header) (SpanContext, error) {
+	sc, err := ParseTraceParent(header.Get("traceparent"))
+	if err != nil {
+		return SpanContext{}, err
+	}
+	sc.State = strings.TrimSpace(strings.Join(header["Tracestate"], ","))
+	return sc, nil
+}
+
+// ParseTraceParent parses a W3C Trace Context "traceparent" header.
+func ParseTraceParent(traceparent string) (SpanContext, error) {
+	traceparent = strings.TrimSpace(traceparent)
+	invalid := fmt.Errorf("invalid traceparent %q", traceparent)
+	// Future versions may append fields, separated by "-".
+	if len(traceparent) < 55 || len(traceparent) > 55 && traceparent[55] != '-' {
+		return SpanContext{}, invalid
+	}
+	parts := strings.Split(traceparent[:55], "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, invalid
+	}
+	if parts[0] == "ff" || parts[0] == "00" && len(traceparent) != 55 {
+		return SpanContext{}, invalid
+	}
+	for _, part := range parts {
+		if strings.ToLower(part) != part {
+			return SpanContext{}, invalid
+		}
+	}
+	sc := SpanContext{}
+	var version, flags [1]byte
+	if _, err := hex.Decode(version[:], []byte(parts[0])); err != nil {
+		return SpanContext{}, invalid
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, invalid
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, invalid
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return SpanContext{}, invalid
+	}
+	sc.Flags = TraceFlags(flags[0])
+	if !sc.IsValid() {
+		return SpanContext{}, invalid
+	}
+	return sc, nil
+}
+
+// SpanContextFromContext returns the span context of the request that ctx
+// was injected into, if any.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey).(SpanContext)
+	return sc, ok
+}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc, which clients
+// propagate to the services they call.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey, sc)
+}
+
+// A Span records a request served by a Server.
+type Span struct {
+	Name       string            `json:"name"` // Name of the matched route, or the request method.
+	TraceID    TraceID           `json:"trace_id"`
+	SpanID     SpanID            `json:"span_id"`
+	ParentID   SpanID            `json:"parent_id"` // Empty for root spans.
+	Flags      TraceFlags        `json:"flags"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Status     int               `json:"status"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Context returns the span context of the span.
+func (s *Span) Context() SpanContext {
+	return SpanContext{TraceID: s.TraceID, SpanID: s.SpanID, Flags: s.Flags}
+}
+
+// A SpanExporter receives spans once they have ended.
+type SpanExporter interface {
+	Export(span *Span) error
+}
+
+// Tracing creates a span for each request, labelled with the name of the
+// matched route, and exports sampled spans to exporter.
+//
+// Requests with a valid "traceparent" header continue the caller's trace,
+// and inherit its sampling decision. Otherwise a new, sampled trace is
+// started.
+func (s *Server) Tracing(exporter SpanExporter) *Server {
+	s.tracer = exporter
+	return s
+}
+
+// startSpan starts the span for a request, and returns the request with its
+// span context.
+func (s *Server) startSpan(r *http.Request) (*Span, *http.Request) {
+	span := &Span{
+		Name:  r.Method,
+		Start: time.Now(),
+		Attributes: map[string]string{
+			"http.method": r.Method,
+			"http.target": r.URL.Path,
+		},
+	}
+	state := ""
+	if parent, err := ExtractSpanContext(r.Header); err == nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Flags = parent.Flags
+		state = parent.State
+	} else {
+		if r.Header.Get("traceparent") != "" {
+			s.log.Debugf("%s %s: %s", r.Method, r.URL, err)
+		}
+		rand.Read(span.TraceID[:])
+		span.Flags = TraceFlagSampled
+	}
+	rand.Read(span.SpanID[:])
+	sc := span.Context()
+	sc.State = state
+	return span, r.WithContext(ContextWithSpanContext(r.Context(), sc))
+}
+
+// endSpan ends and exports the span for a request.
+func (s *Server) endSpan(span *Span, w *recordingResponseWriter) {
+	span.End = time.Now()
+	span.Status = w.Status()
+	if !span.Context().Sampled() {
+		return
+	}
+	s.maybeLogError(s.tracer.Export(span))
+}
+
+// InMemoryExporter collects spans in memory, eg. for tests.
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []*Span
+}
+
+// NewInMemoryExporter creates a new InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (m *InMemoryExporter) Export(span *Span) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = append(m.spans, span)
+	return nil
+}
+
+// Spans returns the spans exported so far.
+func (m *InMemoryExporter) Spans() []*Span {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return append([]*Span{}, m.spans...)
+}
+
+// Reset discards the spans exported so far.
+func (m *InMemoryExporter) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.spans = nil
+}
+
+// JSONLinesExporter writes spans to a stream as JSON, one per line.
+type JSONLinesExporter struct {
+	lock    sync.Mutex
+	w       io.Writer
+	encoder *json.Encoder
+}
+
+// NewJSONLinesExporter creates a JSONLinesExporter writing to w.
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	return &JSONLinesExporter{w: w, encoder: json.NewEncoder(w)}
+}
+
+// OpenJSONLinesExporter creates a JSONLinesExporter appending to the file at
+// path, which is created if necessary. It must be closed with Close().
+func OpenJSONLinesExporter(path string) (*JSONLinesExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLinesExporter(f), nil
+}
+
+func (j *JSONLinesExporter) Export(span *Span) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.encoder.Encode(span)
+}
+
+// Close the underlying writer, if it is an io.Closer.
+func (j *JSONLinesExporter) Close() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if c, ok := j.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package rapid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceParent(t *testing.T) {
+	sc, err := ParseTraceParent(testTraceParent)
+	assert.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, testTraceParent, sc.TraceParent())
+
+	// Future versions may have additional fields.
+	_, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	assert.NoError(t, err)
+
+	for _, invalid := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+	} {
+		_, err = ParseTraceParent(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+type testTracingServer struct {
+	client Client
+}
+
+func (t *testTracingServer) Upstream(ctx context.Context, sc SpanContext) (*indexResponse, error) {
+	resp := &indexResponse{}
+	if err := t.client.DoContext(ctx, Request(nil, "GET", "/downstream").Build(), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *testTracingServer) Downstream(r *http.Request) (*indexResponse, error) {
+	return &indexResponse{len(r.Header.Get("tracestate"))}, nil
+}
+
+// waitForSpans waits for n spans to be exported, as spans are exported after
+// responses have been sent.
+func waitForSpans(exporter *InMemoryExporter, n int) []*Span {
+	for i := 0; i < 100 && len(exporter.Spans()) < n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return exporter.Spans()
+}
+
+func TestTracingPropagation(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Upstream", "/upstream").Get().Response(200, &indexResponse{})
+	svc.Route("Downstream", "/downstream").Get().Response(200, &indexResponse{})
+	handler := &testTracingServer{}
+	exporter := NewInMemoryExporter()
+	ts := httptest.NewServer(newTestServer(t, svc.Build(), handler).Tracing(exporter))
+	defer ts.Close()
+	var err error
+	handler.client, err = Dial(DefaultCodecFactory, ts.URL)
+	assert.NoError(t, err)
+
+	r, _ := http.NewRequest("GET", ts.URL+"/upstream", nil)
+	r.Header.Set("traceparent", testTraceParent)
+	r.Header.Set("tracestate", "vendor=value")
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, 200, resp.StatusCode)
+	// The downstream handler responds with the length of its tracestate.
+	body := &indexResponse{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(body))
+	assert.Equal(t, len("vendor=value"), body.ID)
+
+	spans := waitForSpans(exporter, 2)
+	assert.Equal(t, 2, len(spans))
+	upstream, downstream := spans[0], spans[1]
+	if upstream.Name != "Upstream" {
+		upstream, downstream = downstream, upstream
+	}
+	assert.Equal(t, "Upstream", upstream.Name)
+	assert.Equal(t, "Downstream", downstream.Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", upstream.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", upstream.ParentID.String())
+	assert.Equal(t, upstream.TraceID, downstream.TraceID)
+	assert.Equal(t, upstream.SpanID, downstream.ParentID)
+	assert.NotEqual(t, upstream.SpanID, downstream.SpanID)
+	assert.Equal(t, 200, upstream.Status)
+}
+
+func TestTracingRootSpan(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Downstream", "/downstream").Get().Response(200, &indexResponse{})
+	exporter := NewInMemoryExporter()
+	ts := httptest.NewServer(newTestServer(t, svc.Build(), &testTracingServer{}).Tracing(exporter))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/downstream")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	waitForSpans(exporter, 1)
+	resp, err = http.Get(ts.URL + "/missing")
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	spans := waitForSpans(exporter, 2)
+	assert.Equal(t, 2, len(spans))
+	assert.Equal(t, "Downstream", spans[0].Name)
+	assert.True(t, spans[0].TraceID.IsValid())
+	assert.False(t, spans[0].ParentID.IsValid())
+	assert.True(t, spans[0].Context().Sampled())
+	assert.Equal(t, "GET", spans[1].Name)
+	assert.Equal(t, http.StatusNotFound, spans[1].Status)
+}
+
+func TestTracingUnsampled(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Downstream", "/downstream").Get().Response(200, &indexResponse{})
+	exporter := NewInMemoryExporter()
+	ts := httptest.NewServer(newTestServer(t, svc.Build(), &testTracingServer{}).Tracing(exporter))
+	defer ts.Close()
+
+	r, _ := http.NewRequest("GET", ts.URL+"/downstream", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	time.Sleep(10 * time.Millisecond)
+	assert.Empty(t, exporter.Spans())
+}
+
+func TestJSONLinesExporter(t *testing.T) {
+	w := &bytes.Buffer{}
+	exporter := NewJSONLinesExporter(w)
+	sc, _ := ParseTraceParent(testTraceParent)
+	assert.NoError(t, exporter.Export(&Span{Name: "Route", TraceID: sc.TraceID, SpanID: sc.SpanID, Status: 200}))
+	assert.NoError(t, exporter.Export(&Span{Name: "Other"}))
+	lines := bytes.Split(bytes.TrimSpace(w.Bytes()), []byte("\n"))
+	assert.Equal(t, 2, len(lines))
+	span := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(lines[0], &span))
+	assert.Equal(t, "Route", span["name"])
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", span["span_id"])
+	assert.Equal(t, "", span["parent_id"])
+	assert.NoError(t, exporter.Close())
+}
@@ -2,31 +2,62 @@ package rapid
 
 import (
 	"fmt"
+	"net/http"
 	"net/url"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // EncodeStructToURLValues reflects over a struct, encoding it to a url.Values
 // instance.
 func EncodeStructToURLValues(i interface{}) (values url.Values) {
+	if v, ok := i.(url.Values); ok {
+		return v
+	}
+	return encodeStruct(i, "schema", false)
+}
+
+// EncodeStructToHeader reflects over a struct with "header" tags, encoding it
+// to a http.Header instance. Empty values, and zero values of fields not
+// tagged "required", are omitted.
+func EncodeStructToHeader(i interface{}) http.Header {
+	if h, ok := i.(http.Header); ok {
+		return h
+	}
+	header := http.Header{}
+	for key, values := range encodeStruct(i, "header", true) {
+		for _, value := range values {
+			if value != "" {
+				header.Add(key, value)
+			}
+		}
+	}
+	return header
+}
+
+// encodeStruct encodes the fields of a struct, named by tag or otherwise by
+// the field name. If omitZero is true, fields with zero values are omitted
+// unless the tag includes the "required" option.
+func encodeStruct(i interface{}, tag string, omitZero bool) (values url.Values) {
 	values = url.Values{}
 	if i == nil || reflect.ValueOf(i).IsNil() {
 		return
 	}
-	if v, ok := i.(url.Values); ok {
-		return v
-	}
 	v := reflect.Indirect(reflect.ValueOf(i))
 	t := v.Type()
 	for i := 0; i < v.NumField(); i++ {
 		f := v.Field(i)
 		tf := t.Field(i)
-		name := tf.Tag.Get("schema")
+		options := strings.Split(tf.Tag.Get(tag), ",")
+		name := options[0]
 		if name == "" {
 			name = tf.Name
 		}
+		if omitZero && isZeroValue(f) && !hasTagOption(options[1:], "required") {
+			continue
+		}
 		switch tf.Type.Kind() {
 		case reflect.Slice:
 			for j := 0; j < f.Len(); j++ {
@@ -41,6 +72,15 @@ func EncodeStructToURLValues(i interface{}) (values url.Values) {
 	return
 }
 
+func hasTagOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
 func encodeBaseType(v reflect.Value) string {
 	switch val := v.Interface().(type) {
 	case time.Duration:
@@ -0,0 +1,127 @@
+package rapid
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	structschema "github.com/gorilla/schema"
+)
+
+// Locations of invalid request fields.
+const (
+	LocationPath   = "path"
+	LocationQuery  = "query"
+	LocationHeader = "header"
+	LocationBody   = "body"
+)
+
+// Codes describing why a request field is invalid.
+const (
+	CodeInvalid  = "invalid"  // The value is not valid for the field.
+	CodeRequired = "required" // A required field is missing.
+	CodeUnknown  = "unknown"  // The field does not exist.
+	CodeType     = "type"     // The value is of the wrong type.
+	CodeSyntax   = "syntax"   // The request body is malformed.
+	CodeMin      = "min"      // The value or its length is below the minimum.
+	CodeMax      = "max"      // The value or its length is above the maximum.
+	CodePattern  = "pattern"  // The value does not match the required pattern.
+	CodeOneOf    = "oneof"    // The value is not one of the allowed values.
+)
+
+// A FieldError describes a single invalid field in a request.
+type FieldError struct {
+	Field    string `json:"field,omitempty"`
+	Location string `json:"location,omitempty"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+}
+
+func (f *FieldError) Error() string {
+	if f.Field == "" {
+		return f.Message
+	}
+	return fmt.Sprintf("%s: %s", f.Field, f.Message)
+}
+
+// A ValidationError is returned when one or more fields of a request are
+// invalid. It is sent to clients with status 400, and is returned by
+// DecodeResponse() on the client.
+//
+// Validator implementations can return a ValidationError to report specific
+// fields. Location is filled in by the server if omitted.
+type ValidationError struct {
+	Fields []*FieldError `json:"fields"`
+}
+
+func (v *ValidationError) Error() string {
+	messages := []string{}
+	for _, field := range v.Fields {
+		messages = append(messages, field.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// newValidationError converts an error from decoding or validating part of a
+// request into a *ValidationError. *HTTPStatus errors are returned
+// unchanged.
+func newValidationError(location string, err error) error {
+	switch err := err.(type) {
+	case *HTTPStatus:
+		return err
+
+	case *ValidationError:
+		for _, field := range err.Fields {
+			if field.Location == "" {
+				field.Location = location
+			}
+		}
+		return err
+
+	case structschema.MultiError:
+		keys := []string{}
+		for key := range err {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fields := []*FieldError{}
+		for _, key := range keys {
+			fields = append(fields, schemaFieldError(location, key, err[key]))
+		}
+		return &ValidationError{Fields: fields}
+
+	case *json.SyntaxError:
+		return &ValidationError{Fields: []*FieldError{
+			{Location: location, Code: CodeSyntax, Message: err.Error()},
+		}}
+
+	case *json.UnmarshalTypeError:
+		return &ValidationError{Fields: []*FieldError{
+			{Field: err.Field, Location: location, Code: CodeType, Message: fmt.Sprintf("expected %s but got %s", err.Type, err.Value)},
+		}}
+	}
+	if err == io.ErrUnexpectedEOF {
+		return &ValidationError{Fields: []*FieldError{
+			{Location: location, Code: CodeSyntax, Message: "unexpected end of input"},
+		}}
+	}
+	return &ValidationError{Fields: []*FieldError{
+		{Location: location, Code: CodeInvalid, Message: err.Error()},
+	}}
+}
+
+func schemaFieldError(location, key string, err error) *FieldError {
+	switch err := err.(type) {
+	case structschema.ConversionError:
+		return &FieldError{Field: err.Key, Location: location, Code: CodeType, Message: fmt.Sprintf("expected %s", err.Type)}
+
+	case structschema.EmptyFieldError:
+		return &FieldError{Field: err.Key, Location: location, Code: CodeRequired, Message: "required"}
+
+	case structschema.UnknownKeyError:
+		return &FieldError{Field: err.Key, Location: location, Code: CodeUnknown, Message: "unknown field"}
+	}
+	return &FieldError{Field: key, Location: location, Code: CodeInvalid, Message: err.Error()}
+}
@@ -0,0 +1,81 @@
+package rapid
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type validatedRequest struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func (v *validatedRequest) Validate() error {
+	if v.Name == "" {
+		return &ValidationError{Fields: []*FieldError{{Field: "name", Code: CodeRequired, Message: "name is required"}}}
+	}
+	return nil
+}
+
+type testValidationServer struct{}
+
+func (t *testValidationServer) Create(path *pathData, req *validatedRequest) error {
+	return nil
+}
+
+func serveTestValidation(t *testing.T, path string, body string) (int, *ErrorResponse) {
+	svc := Define("Test")
+	svc.Route("Create", "/{id}").Post().Path(&pathData{}).Request(&validatedRequest{}).Response(http.StatusCreated, nil)
+	svr, err := NewServer(svc.Build(), &testValidationServer{})
+	assert.NoError(t, err)
+	w := serveTestRequest(svr, "POST", path, body, nil)
+	response := &ErrorResponse{}
+	if w.Code != http.StatusCreated {
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), response))
+	}
+	return w.Code, response
+}
+
+func TestValidationErrorFromPath(t *testing.T) {
+	status, response := serveTestValidation(t, "/abc", `{"name": "bob"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, []*FieldError{{Field: "id", Location: LocationPath, Code: CodeType, Message: "expected int"}}, response.Fields)
+	assert.Equal(t, "id: expected int", response.Error)
+}
+
+func TestValidationErrorFromJSON(t *testing.T) {
+	status, response := serveTestValidation(t, "/1", `{"name": `)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, []*FieldError{{Location: LocationBody, Code: CodeSyntax, Message: "unexpected end of input"}}, response.Fields)
+
+	status, response = serveTestValidation(t, "/1", `{"name": "bob", "age": "ten"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, []*FieldError{{Field: "age", Location: LocationBody, Code: CodeType, Message: "expected int but got string"}}, response.Fields)
+}
+
+func TestValidationErrorFromValidator(t *testing.T) {
+	status, response := serveTestValidation(t, "/1", `{"age": 10}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, []*FieldError{{Field: "name", Location: LocationBody, Code: CodeRequired, Message: "name is required"}}, response.Fields)
+
+	status, _ = serveTestValidation(t, "/1", `{"name": "bob", "age": 10}`)
+	assert.Equal(t, http.StatusCreated, status)
+}
+
+func TestClientDecodesValidationError(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Create", "/{id}").Post().Path(&pathData{}).Request(&validatedRequest{}).Response(http.StatusCreated, nil)
+	svr, _ := NewServer(svc.Build(), &testValidationServer{})
+	ts := httptest.NewServer(svr)
+	defer ts.Close()
+
+	client, _ := Dial(DefaultCodecFactory, ts.URL)
+	err := client.Do(Request(nil, "POST", "/{id}", 1).Body(&validatedRequest{}).Build(), &struct{}{})
+	assert.Equal(t, &ValidationError{Fields: []*FieldError{
+		{Field: "name", Location: LocationBody, Code: CodeRequired, Message: "name is required"},
+	}}, err)
+}
@@ -0,0 +1,493 @@
+package rapid
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/codegangsta/inject"
+)
+
+// Minimal RFC 6455 WebSocket implementation, sufficient for exchanging
+// codec-encoded messages between rapid servers and clients.
+
+const (
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// Maximum size of a single (possibly fragmented) WebSocket message.
+	maxWebSocketMessageSize = 16 << 20
+)
+
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const (
+	wsCloseNormal        = 1000
+	wsCloseProtocolError = 1002
+	wsCloseInternalError = 1011
+)
+
+var (
+	errWebSocketMessageTooLarge = errors.New("websocket message too large")
+	errWebSocketProtocol        = errors.New("websocket protocol error")
+)
+
+// A ClientConn is a bidirectional stream of messages with a WebSocket route.
+type ClientConn interface {
+	// Send encodes v and sends it as a single message.
+	Send(v interface{}) error
+	// Next decodes the next message into v. io.EOF is returned when the
+	// server closes the connection, and in-band error frames are returned as
+	// *HTTPStatus errors.
+	Next(v interface{}) error
+	Close() error
+}
+
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContainsToken(h http.Header, key, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(key)] {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type wsConn struct {
+	r      *bufio.Reader
+	w      io.Writer
+	c      io.Closer
+	client bool // Clients must mask outgoing frames.
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// upgradeWebSocket performs the server side of the WebSocket handshake.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != "GET" ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, Error(http.StatusBadRequest, "expected WebSocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, ErrorWithHeaders(http.StatusBadRequest, "unsupported WebSocket version", http.Header{
+			"Sec-WebSocket-Version": {"13"},
+		})
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, Error(http.StatusBadRequest, "missing Sec-WebSocket-Key")
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, Error(http.StatusInternalServerError, "HTTP writer does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{r: rw.Reader, w: conn, c: conn}, nil
+}
+
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if c.client {
+		header[1] |= 0x80
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return err
+		}
+		header = append(header, mask...)
+		masked := make([]byte, len(payload))
+		for i, b := range payload {
+			masked[i] = b ^ mask[i%4]
+		}
+		payload = masked
+	}
+	if opcode == wsClose {
+		c.closed = true
+	}
+	if _, err := c.w.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(c.r, header); err != nil {
+		return
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	// No extensions are negotiated, so RSV bits must be clear, and only
+	// frames sent by clients are masked.
+	if header[0]&0x70 != 0 || masked == c.client {
+		err = errWebSocketProtocol
+		return
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(c.r, ext); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext)
+	}
+	// Control frames must not be fragmented and are limited to 125 bytes.
+	if opcode&0x8 != 0 && (!fin || size > 125) {
+		err = errWebSocketProtocol
+		return
+	}
+	if size > maxWebSocketMessageSize {
+		err = errWebSocketMessageTooLarge
+		return
+	}
+	mask := make([]byte, 4)
+	if masked {
+		if _, err = io.ReadFull(c.r, mask); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, size)
+	if _, err = io.ReadFull(c.r, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// readMessage reads the next complete data message, transparently handling
+// fragmentation and control frames. io.EOF is returned once the peer has
+// closed the connection. Protocol violations close the connection with status
+// 1002 and return errWebSocketProtocol.
+func (c *wsConn) readMessage() ([]byte, error) {
+	message, err := c.readFrames()
+	if err == errWebSocketProtocol {
+		c.writeClose(wsCloseProtocolError, "")
+	}
+	return message, err
+}
+
+func (c *wsConn) readFrames() ([]byte, error) {
+	var message []byte
+	fragmented := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+
+		case wsPong:
+			continue
+
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return nil, io.EOF
+
+		case wsText, wsBinary, wsContinuation:
+			// A continuation must follow an unfinished data frame, and a new
+			// data frame must not interrupt one.
+			if (opcode == wsContinuation) != fragmented {
+				return nil, errWebSocketProtocol
+			}
+			fragmented = !fin
+			message = append(message, payload...)
+			if len(message) > maxWebSocketMessageSize {
+				return nil, errWebSocketMessageTooLarge
+			}
+			if fin {
+				return message, nil
+			}
+
+		default:
+			return nil, errWebSocketProtocol
+		}
+	}
+}
+
+// writeMessage sends message as a text frame if it is valid UTF-8, or as a
+// binary frame otherwise.
+func (c *wsConn) writeMessage(message []byte) error {
+	opcode := byte(wsBinary)
+	if utf8.Valid(message) {
+		opcode = wsText
+	}
+	return c.writeFrame(opcode, message)
+}
+
+// shutdown sends a close frame, if one has not already been sent, and closes
+// the underlying connection.
+func (c *wsConn) shutdown(code int, reason string) error {
+	c.writeClose(code, reason)
+	return c.c.Close()
+}
+
+// writeClose sends a close frame with the given status code.
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(wsClose, payload)
+}
+
+// handleWebSocket upgrades the connection and invokes the handler with typed
+// inbound and outbound message channels. Messages are decoded from and
+// encoded to frames with the server's codec. The connection is closed when
+// the handler returns.
+func (s *Server) handleWebSocket(match *routeMatch, i inject.Injector, w http.ResponseWriter, r *http.Request) {
+	ws := match.route.WebSocket
+	in := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, ws.InType), 0)
+	out := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, ws.OutType), 0)
+	i.Set(reflect.ChanOf(reflect.RecvDir, ws.InType), in.Convert(reflect.ChanOf(reflect.RecvDir, ws.InType)))
+	i.Set(reflect.ChanOf(reflect.SendDir, ws.OutType), out.Convert(reflect.ChanOf(reflect.SendDir, ws.OutType)))
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		s.maybeLogError(s.codec.Response(nil).EncodeResponse(r, w, 0, err))
+		return
+	}
+
+	done := make(chan struct{})
+
+	// Decode inbound messages.
+	go func() {
+		defer in.Close()
+		for {
+			message, err := conn.readMessage()
+			if err != nil {
+				if err != io.EOF {
+					s.log.Debugf("%s %s: websocket read failed: %s", r.Method, r.URL, err)
+				}
+				return
+			}
+			v, vi := makeValueAndInterface(ws.InType)
+			if err := s.codec.Stream(vi).DecodeFrame(bufio.NewReader(bytes.NewReader(message))); err != nil {
+				s.log.Warningf("%s %s: invalid websocket message: %s", r.Method, r.URL, err)
+				continue
+			}
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: in, Send: reflect.ValueOf(v())},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+			})
+			if chosen == 1 {
+				return
+			}
+		}
+	}()
+
+	// Encode outbound messages. After a write failure messages are discarded
+	// so that the handler does not block.
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		var failed error
+		for {
+			chosen, v, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: out},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+			})
+			if chosen == 1 {
+				return
+			}
+			if failed != nil {
+				continue
+			}
+			_, vi := valueAndInterface(v.Interface())
+			buf := &bytes.Buffer{}
+			if failed = s.codec.Stream(vi).EncodeFrame(buf, nil); failed == nil {
+				failed = conn.writeMessage(buf.Bytes())
+			}
+			if failed != nil {
+				s.log.Debugf("%s %s: websocket write failed: %s", r.Method, r.URL, failed)
+			}
+		}
+	}()
+
+	result, err := s.invokeWebSocket(match, i, r)
+	close(done)
+	<-written
+	if err == nil && len(result) > 0 {
+		if rerr := result[len(result)-1]; rerr.Kind() == reflect.Interface && !rerr.IsNil() {
+			err, _ = rerr.Interface().(error)
+		}
+	}
+	if err != nil {
+		s.log.Errorf("%s %s: %s", r.Method, r.URL, err)
+		buf := &bytes.Buffer{}
+		if s.codec.Stream(nil).EncodeFrame(buf, err) == nil {
+			conn.writeMessage(buf.Bytes())
+		}
+		s.maybeLogError(conn.shutdown(wsCloseInternalError, ""))
+		return
+	}
+	s.maybeLogError(conn.shutdown(wsCloseNormal, ""))
+}
+
+// invokeWebSocket calls a WebSocket handler method. As the connection has
+// been hijacked, a panic is recovered here and returned as an error.
+func (s *Server) invokeWebSocket(match *routeMatch, i inject.Injector, r *http.Request) (result []reflect.Value, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			s.reportPanic(r, v)
+			result, err = nil, ErrorForStatus(http.StatusInternalServerError)
+		}
+	}()
+	return i.Invoke(match.method.Interface())
+}
+
+// DoWebSocket opens a WebSocket connection to a route defined with
+// route.WebSocket(). Messages are encoded and decoded with the client's codec.
+func (b *BasicClient) DoWebSocket(req *RequestTemplate) (ClientConn, error) {
+	return b.DoWebSocketContext(context.Background(), req)
+}
+
+// DoWebSocketContext is like DoWebSocket, but the connection is closed when
+// ctx is done.
+func (b *BasicClient) DoWebSocketContext(ctx context.Context, req *RequestTemplate) (ClientConn, error) {
+	hr := req.Build(b.url).WithContext(ctx)
+	hr.Body = nil
+	hr.ContentLength = 0
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	nonce := base64.StdEncoding.EncodeToString(key)
+	hr.Header.Set("Connection", "Upgrade")
+	hr.Header.Set("Upgrade", "websocket")
+	hr.Header.Set("Sec-WebSocket-Version", "13")
+	hr.Header.Set("Sec-WebSocket-Key", nonce)
+	if b.beforeHook != nil {
+		if err := b.beforeHook(hr); err != nil {
+			return nil, err
+		}
+	}
+	response, err := b.httpClient.Do(hr)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		defer response.Body.Close()
+		err = b.codec.Response(nil).DecodeResponse(response)
+		if err == nil {
+			err = ErrorForStatus(response.StatusCode)
+		}
+		return nil, err
+	}
+	rwc, ok := response.Body.(io.ReadWriteCloser)
+	if !ok || response.Header.Get("Sec-WebSocket-Accept") != websocketAccept(nonce) {
+		response.Body.Close()
+		return nil, Error(http.StatusBadGateway, "invalid WebSocket handshake")
+	}
+	conn := &basicClientConn{
+		codec: b.codec,
+		conn:  &wsConn{r: bufio.NewReader(rwc), w: rwc, c: rwc, client: true},
+		done:  make(chan struct{}),
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				rwc.Close()
+			case <-conn.done:
+			}
+		}()
+	}
+	return conn, nil
+}
+
+type basicClientConn struct {
+	codec CodecFactory
+	conn  *wsConn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (b *basicClientConn) Send(v interface{}) error {
+	_, vi := valueAndInterface(v)
+	buf := &bytes.Buffer{}
+	if err := b.codec.Stream(vi).EncodeFrame(buf, nil); err != nil {
+		return err
+	}
+	return b.conn.writeMessage(buf.Bytes())
+}
+
+func (b *basicClientConn) Next(v interface{}) error {
+	message, err := b.conn.readMessage()
+	if err != nil {
+		return err
+	}
+	_, vi := valueAndInterface(v)
+	return b.codec.Stream(vi).DecodeFrame(bufio.NewReader(bytes.NewReader(message)))
+}
+
+func (b *basicClientConn) Close() error {
+	b.once.Do(func() { close(b.done) })
+	return b.conn.shutdown(wsCloseNormal, "")
+}
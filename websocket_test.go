@@ -0,0 +1,125 @@
+package rapid
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testWebSocketMessage struct {
+	Text string
+}
+
+type testWebSocketServer struct{}
+
+func (t *testWebSocketServer) Echo(in <-chan *testWebSocketMessage, out chan<- *testWebSocketMessage) error {
+	for msg := range in {
+		if msg.Text == "fail" {
+			return Error(http.StatusTeapot, "failed")
+		}
+		out <- &testWebSocketMessage{strings.ToUpper(msg.Text)}
+	}
+	return nil
+}
+
+func TestWebSocket(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").WebSocket(&testWebSocketMessage{}, &testWebSocketMessage{})
+	ts := httptest.NewServer(newTestServer(t, svc.Build(), &testWebSocketServer{}))
+	defer ts.Close()
+
+	client, _ := Dial(DefaultCodecFactory, ts.URL)
+	conn, err := client.DoWebSocket(Request(nil, "GET", "/echo").Build())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	msg := &testWebSocketMessage{}
+	for _, text := range []string{"hello", strings.Repeat("x", 70000)} {
+		assert.NoError(t, conn.Send(&testWebSocketMessage{text}))
+		assert.NoError(t, conn.Next(msg))
+		assert.Equal(t, strings.ToUpper(text), msg.Text)
+	}
+
+	assert.NoError(t, conn.Send(&testWebSocketMessage{"fail"}))
+	err = conn.Next(msg)
+	assert.Equal(t, http.StatusTeapot, err.(*HTTPStatus).Status)
+	assert.Equal(t, io.EOF, conn.Next(msg))
+}
+
+func TestWebSocketRequiresUpgrade(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").WebSocket(&testWebSocketMessage{}, &testWebSocketMessage{})
+	svr, _ := NewServer(svc.Build(), &testWebSocketServer{})
+	r, _ := http.NewRequest("GET", "/echo", nil)
+	w := httptest.NewRecorder()
+	svr.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWebSocketRejectsInvalidFrames(t *testing.T) {
+	for _, test := range []struct {
+		client bool
+		frame  []byte
+		err    error
+	}{
+		{false, []byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'}, nil},
+		{false, []byte{0x81, 0x02, 'h', 'i'}, errWebSocketProtocol},             // Unmasked client frame.
+		{false, []byte{0xc1, 0x82, 0, 0, 0, 0, 'h', 'i'}, errWebSocketProtocol}, // RSV1 set.
+		{true, []byte{0x81, 0x02, 'h', 'i'}, nil},
+		{true, []byte{0x81, 0x82, 0, 0, 0, 0, 'h', 'i'}, errWebSocketProtocol}, // Masked server frame.
+		{true, []byte{0x09, 0x02, 'h', 'i'}, errWebSocketProtocol},             // Fragmented ping.
+		{true, []byte{0x89, 0x7e, 0x00, 0x7e}, errWebSocketProtocol},           // Oversized ping.
+	} {
+		conn := &wsConn{r: bufio.NewReader(bytes.NewReader(test.frame)), client: test.client}
+		_, _, payload, err := conn.readFrame()
+		assert.Equal(t, test.err, err, "%x", test.frame)
+		if err == nil {
+			assert.Equal(t, "hi", string(payload))
+		}
+	}
+}
+
+func TestWebSocketRejectsInvalidFragments(t *testing.T) {
+	for _, frames := range [][]byte{
+		{0x80, 0x02, 'h', 'i'},                         // Continuation without a data frame.
+		{0x01, 0x01, 'h', 0x81, 0x01, 'i'},             // Data frame interrupting a fragmented message.
+		{0x01, 0x01, 'h', 0x80, 0x01, 'i', 0x80, 0x00}, // Continuation after the message finished.
+		{0x83, 0x02, 'h', 'i'},                         // Reserved opcode.
+	} {
+		w := &bytes.Buffer{}
+		conn := &wsConn{r: bufio.NewReader(bytes.NewReader(frames)), w: w, client: true}
+		for {
+			if _, err := conn.readMessage(); err != nil {
+				assert.Equal(t, errWebSocketProtocol, err, "%x", frames)
+				break
+			}
+		}
+		// Close frame with status 1002, masked by the client.
+		closeFrame := w.Bytes()
+		if assert.Len(t, closeFrame, 8, "%x", frames) {
+			assert.Equal(t, []byte{0x88, 0x82}, closeFrame[:2])
+			status := []byte{closeFrame[6] ^ closeFrame[2], closeFrame[7] ^ closeFrame[3]}
+			assert.Equal(t, []byte{0x03, 0xea}, status)
+		}
+	}
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// Example from RFC 6455.
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestWebSocketRAML(t *testing.T) {
+	svc := Define("Test")
+	svc.Route("Echo", "/echo").WebSocket(&testWebSocketMessage{}, &testWebSocketMessage{})
+	w := &bytes.Buffer{}
+	err := SchemaToRAML("http://localhost:8080", svc.Build(), w)
+	assert.NoError(t, err)
+	assert.Contains(t, w.String(), "WebSocket ws://localhost:8080/echo")
+}